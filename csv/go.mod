@@ -0,0 +1,3 @@
+module github.com/cherry77/go-learn/csv
+
+go 1.21
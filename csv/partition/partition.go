@@ -0,0 +1,275 @@
+// Package partition 是两个 CSV 拆分脚本共用的分区逻辑：按一列或多列的值
+// 把记录写入由模板决定的输出文件。
+package partition
+
+import (
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxKeyLen 是清洗后键值的最大字节数，超出的部分换成原值的哈希
+const maxKeyLen = 100
+
+var placeholder = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// Partitioner 按分区键把记录写入各自的文件。输出文件在多次 Process 之间
+// 共享，多个 CSV 中相同的键会写进同一个文件，而不是互相覆盖。
+type Partitioner struct {
+	dir      string
+	by       []string
+	template string
+	empty    string
+	require  []string
+
+	files   map[string]*partitionFile // 按输出路径
+	owners  map[string]string         // 输出路径 -> 原始键，用于发现清洗后重名
+	dropped int
+}
+
+type partitionFile struct {
+	file   *os.File
+	writer *csv.Writer
+	header []string
+}
+
+// Options 是 New 的参数。
+type Options struct {
+	Dir      string   // 输出目录
+	By       string   // 分区列，多列用逗号分隔，不区分大小写
+	Template string   // 输出路径模板，默认 {列1}_{列2}.csv
+	Empty    string   // 键值为空时使用的分区名；为空则丢弃这些行
+	Vars     []string // 除分区列外模板可以使用的占位符，值由 Process 提供
+	Require  []string // 每个 CSV 还必须包含的列
+}
+
+// New 检查分区列和模板。模板必须用到每个分区列，否则不同的键会写进同一个文件。
+func New(opts Options) (*Partitioner, error) {
+	p := &Partitioner{
+		dir:      opts.Dir,
+		template: opts.Template,
+		empty:    opts.Empty,
+		files:    make(map[string]*partitionFile),
+		owners:   make(map[string]string),
+	}
+	for _, column := range strings.Split(opts.By, ",") {
+		if column = strings.ToLower(strings.TrimSpace(column)); column != "" {
+			p.by = append(p.by, column)
+		}
+	}
+	if len(p.by) == 0 {
+		return nil, fmt.Errorf("-by needs at least one column")
+	}
+	for _, column := range opts.Require {
+		p.require = append(p.require, strings.ToLower(column))
+	}
+	if p.template == "" {
+		p.template = "{" + strings.Join(p.by, "}_{") + "}.csv"
+	}
+
+	used := make(map[string]bool)
+	for _, m := range placeholder.FindAllStringSubmatch(p.template, -1) {
+		name := strings.ToLower(m[1])
+		if !contains(opts.Vars, name) && !contains(p.by, name) {
+			want := "one of the -by columns"
+			if len(opts.Vars) > 0 {
+				want = "{" + strings.Join(opts.Vars, "}, {") + "} or " + want
+			}
+			return nil, fmt.Errorf("template %s: unknown placeholder {%s} (want %s)", p.template, m[1], want)
+		}
+		used[name] = true
+	}
+	for _, column := range p.by {
+		if !used[column] {
+			return nil, fmt.Errorf("template %s does not use -by column {%s}", p.template, column)
+		}
+	}
+	return p, nil
+}
+
+// Dropped 返回因键值为空而丢弃的行数。
+func (p *Partitioner) Dropped() int { return p.dropped }
+
+// Partitions 返回已创建的输出文件数。
+func (p *Partitioner) Partitions() int { return len(p.files) }
+
+// SanitizeKey 把键值变成安全的文件名：路径分隔符、控制字符和 Windows
+// 不允许的字符换成 _，去掉首尾的空格和点，所以不会得到 .. 或隐藏文件。
+// 过长的值截断后加上原值的哈希，不同的长键不会因截断而重名。
+func SanitizeKey(v string) string {
+	original := v
+	v = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, v)
+	v = strings.Trim(v, " .")
+	if len(v) > maxKeyLen {
+		h := fnv.New32a()
+		h.Write([]byte(original))
+		suffix := fmt.Sprintf("~%08x", h.Sum32())
+		v = strings.ToValidUTF8(v[:maxKeyLen-len(suffix)], "") + suffix
+	}
+	if v == "" {
+		return "_"
+	}
+	// Windows 保留的设备名不能作为文件名
+	base := strings.ToUpper(strings.SplitN(v, ".", 2)[0])
+	switch base {
+	case "CON", "PRN", "AUX", "NUL",
+		"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+		"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9":
+		return "_" + v
+	}
+	return v
+}
+
+// Process 读取一个 CSV 并把每行写入它的分区。vars 提供 Options.Vars 中
+// 占位符的值，会先经过 SanitizeKey。
+func (p *Partitioner) Process(reader io.Reader, vars map[string]string) error {
+	csvReader := csv.NewReader(reader)
+
+	// 读取标题行
+	headers, err := csvReader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read headers: %v", err)
+	}
+
+	// 查找分区列索引
+	index := make(map[string]int, len(headers))
+	for i, header := range headers {
+		if _, ok := index[strings.ToLower(header)]; !ok {
+			index[strings.ToLower(header)] = i
+		}
+	}
+	for _, column := range append(append([]string(nil), p.require...), p.by...) {
+		if _, ok := index[column]; !ok {
+			return fmt.Errorf("CSV must contain a '%s' column", column)
+		}
+	}
+	keyIndexes := make([]int, len(p.by))
+	for i, column := range p.by {
+		keyIndexes[i] = index[column]
+	}
+
+	writers := make(map[string]*csv.Writer) // 原始键 -> 写入器
+	values := make(map[string]string, len(vars)+len(p.by))
+	for name, v := range vars {
+		values[name] = SanitizeKey(v)
+	}
+	raw := make([]string, len(p.by))
+
+	// 处理每一行数据
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Warning: error reading record: %v", err)
+			continue
+		}
+
+		drop := false
+		for i, column := range p.by {
+			value := record[keyIndexes[i]]
+			if value == "" {
+				if p.empty == "" {
+					drop = true
+					break
+				}
+				value = p.empty
+			}
+			raw[i] = value
+			values[column] = SanitizeKey(value)
+		}
+		if drop {
+			p.dropped++
+			continue
+		}
+
+		key := strings.Join(raw, "\x1f")
+		writer, ok := writers[key]
+		if !ok {
+			path := placeholder.ReplaceAllStringFunc(p.template, func(m string) string {
+				return values[strings.ToLower(m[1:len(m)-1])]
+			})
+			if writer, err = p.writer(filepath.Clean(path), strings.Join(raw, "/"), headers); err != nil {
+				return err
+			}
+			writers[key] = writer
+		}
+
+		// 写入记录
+		if err := writer.Write(record); err != nil {
+			log.Printf("Warning: failed to write record: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// writer 返回分区的写入器，第一次用到时创建文件并写入标题行。
+// Process 按原始键缓存结果，所以每个 CSV 中每个键只调用一次。
+func (p *Partitioner) writer(path, key string, headers []string) (*csv.Writer, error) {
+	if owner, ok := p.owners[path]; ok && owner != key {
+		return nil, fmt.Errorf("keys %q and %q both map to %s after sanitizing; use a template that keeps them apart", owner, key, path)
+	}
+	if f, ok := p.files[path]; ok {
+		if !strings.EqualFold(strings.Join(f.header, ","), strings.Join(headers, ",")) {
+			return nil, fmt.Errorf("%s: header differs from the CSV that created it", path)
+		}
+		return f.writer, nil
+	}
+	p.owners[path] = key
+
+	// 创建新文件
+	outputFilename := filepath.Join(p.dir, path)
+	if err := os.MkdirAll(filepath.Dir(outputFilename), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %v", err)
+	}
+	outputFile, err := os.Create(outputFilename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %v", err)
+	}
+
+	// 创建 CSV 写入器并写入标题行
+	writer := csv.NewWriter(outputFile)
+	if err := writer.Write(headers); err != nil {
+		outputFile.Close()
+		return nil, fmt.Errorf("failed to write headers: %v", err)
+	}
+	p.files[path] = &partitionFile{file: outputFile, writer: writer, header: headers}
+	return writer, nil
+}
+
+// Close 刷新并关闭所有输出文件，返回第一个错误
+func (p *Partitioner) Close() error {
+	var first error
+	for path, f := range p.files {
+		f.writer.Flush()
+		if err := f.writer.Error(); err != nil && first == nil {
+			first = fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		if err := f.file.Close(); err != nil && first == nil {
+			first = fmt.Errorf("failed to close %s: %v", path, err)
+		}
+	}
+	return first
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package partition
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeKey(t *testing.T) {
+	cases := map[string]string{
+		"US":              "US",
+		"a/b":             "a_b",
+		`..\..\etc`:       `_.._etc`,
+		"..":              "_",
+		" .hidden. ":      "hidden",
+		"":                "_",
+		"tab\there":       "tab_here",
+		`<>:"|?*`:         "_______",
+		"con":             "_con",
+		"LPT1.csv":        "_LPT1.csv",
+		"console":         "console",
+		"中国":              "中国",
+		"line\nbreak\x7f": "line_break_",
+	}
+	for in, want := range cases {
+		if got := SanitizeKey(in); got != want {
+			t.Errorf("SanitizeKey(%q): expected %q, got %q", in, want, got)
+		}
+	}
+
+	// long keys are cut to 100 bytes, stay valid UTF-8 and stay distinct
+	long := strings.Repeat("国", 60)
+	a, b := SanitizeKey(long+"a"), SanitizeKey(long+"b")
+	if len(a) > maxKeyLen || !strings.HasPrefix(a, strings.Repeat("国", 30)) || a == b {
+		t.Errorf("Expected distinct truncated keys, got %q and %q", a, b)
+	}
+	if !utf8.ValidString(a) {
+		t.Errorf("Expected valid UTF-8, got %q", a)
+	}
+}
+
+func TestNew_Template(t *testing.T) {
+	cases := []struct {
+		by, template string
+		vars         []string
+		err          string
+	}{
+		{"country_code", "", nil, ""},
+		{"Country_Code, platform", "{country_code}/{PLATFORM}.csv", nil, ""},
+		{"country_code", "{file}/{country_code}.csv", []string{"file"}, ""},
+		{" , ", "", nil, "at least one column"},
+		{"country_code,platform", "{country_code}.csv", nil, "does not use -by column {platform}"},
+		{"country_code", "{file}_{country_code}.csv", nil, "unknown placeholder {file}"},
+		{"country_code", "{date}_{country_code}.csv", []string{"file"}, "want {file} or one of the -by columns"},
+	}
+	for _, c := range cases {
+		_, err := New(Options{By: c.by, Template: c.template, Vars: c.vars})
+		if (c.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("-by %q -template %q: expected error %q, got %v", c.by, c.template, c.err, err)
+		}
+	}
+}
+
+// readTree returns every file under dir with its content.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	return files
+}
+
+func names(files map[string]string) string {
+	var list []string
+	for name := range files {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return strings.Join(list, " ")
+}
+
+const sample = "advertising_id,Country_Code,platform\n" +
+	"a1,US,ios\n" +
+	"a2,,ios\n" +
+	"a3,US,android\n" +
+	"a4,DE,\n"
+
+func TestProcess_Empty(t *testing.T) {
+	dir := t.TempDir()
+	p, err := New(Options{Dir: dir, By: "country_code,platform", Template: "{country_code}/{platform}.csv", Empty: "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Process(strings.NewReader(sample), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files := readTree(t, dir)
+	if got := names(files); got != "DE/unknown.csv US/android.csv US/ios.csv unknown/ios.csv" {
+		t.Errorf("Unexpected partitions %s", got)
+	}
+	if files["unknown/ios.csv"] != "advertising_id,Country_Code,platform\na2,,ios\n" || p.Dropped() != 0 {
+		t.Errorf("Expected the empty country bucketed, got %q", files["unknown/ios.csv"])
+	}
+
+	// no bucket: rows with any empty key column are dropped
+	dir = t.TempDir()
+	p, _ = New(Options{Dir: dir, By: "country_code,platform"})
+	if err := p.Process(strings.NewReader(sample), nil); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if got := names(readTree(t, dir)); got != "US_android.csv US_ios.csv" || p.Dropped() != 2 || p.Partitions() != 2 {
+		t.Errorf("Expected 2 rows dropped, got %s and %d dropped", got, p.Dropped())
+	}
+}
+
+func TestProcess_SharedAcrossFiles(t *testing.T) {
+	dir := t.TempDir()
+	p, err := New(Options{Dir: dir, By: "country_code", Empty: "unknown", Vars: []string{"file"}, Require: []string{"advertising_id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Process(strings.NewReader(sample), map[string]string{"file": "day1"}); err != nil {
+		t.Fatal(err)
+	}
+	// same columns in another case: still the same output files
+	if err := p.Process(strings.NewReader("ADVERTISING_ID,country_code,PLATFORM\nb1,US,ios\n"), map[string]string{"file": "day2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Process(strings.NewReader("advertising_id,country_code\nc1,US\n"), nil); err == nil || !strings.Contains(err.Error(), "header differs") {
+		t.Errorf("Expected a different header to be rejected, got %v", err)
+	}
+	if err := p.Process(strings.NewReader("country_code,platform\nUS,ios\n"), nil); err == nil || !strings.Contains(err.Error(), "'advertising_id'") {
+		t.Errorf("Expected the required column to be checked, got %v", err)
+	}
+	p.Close()
+
+	files := readTree(t, dir)
+	if files["US.csv"] != "advertising_id,Country_Code,platform\na1,US,ios\na3,US,android\nb1,US,ios\n" {
+		t.Errorf("Expected both CSVs in US.csv, got %q", files["US.csv"])
+	}
+	if got := names(files); got != "DE.csv US.csv unknown.csv" {
+		t.Errorf("Unexpected partitions %s", got)
+	}
+}
+
+func TestProcess_SanitizeCollision(t *testing.T) {
+	p, _ := New(Options{Dir: t.TempDir(), By: "country_code"})
+	err := p.Process(strings.NewReader("country_code\na/b\na_b\n"), nil)
+	if err == nil || !strings.Contains(err.Error(), "both map to a_b.csv") {
+		t.Errorf("Expected keys that sanitize alike to be an error, got %v", err)
+	}
+	p.Close()
+
+	dir := t.TempDir()
+	p, _ = New(Options{Dir: dir, By: "country_code"})
+	long := strings.Repeat("x", 150)
+	if err := p.Process(strings.NewReader("country_code\n"+long+"1\n"+long+"2\n"), nil); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if p.Partitions() != 2 || len(readTree(t, dir)) != 2 {
+		t.Errorf("Expected two files for keys that differ after 100 bytes, got %s", names(readTree(t, dir)))
+	}
+}
@@ -3,25 +3,50 @@ package main
 import (
 	"archive/tar"
 	"compress/gzip"
-	"encoding/csv"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/cherry77/go-learn/csv/partition"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatal("Usage: go run script.go <input.csv.tar.gz>")
+	by := flag.String("by", "country_code", "comma-separated columns to partition on")
+	template := flag.String("template", "", "output path template, e.g. {country_code}/{platform}.csv; placeholders are the -by columns and {file}, the CSV name inside the archive (default {col1}_{col2}.csv)")
+	empty := flag.String("empty", "unknown", "bucket used for an empty key value; an empty string drops those rows")
+	outputDir := flag.String("o", "", "output directory (default <input>_split)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: go run split_csv.go [flags] <input.csv.tar.gz>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
 	}
 
-	inputFile := os.Args[1]
-	outputDir := strings.TrimSuffix(filepath.Base(inputFile), ".tar.gz") + "_split"
+	inputFile := flag.Arg(0)
+	if *outputDir == "" {
+		*outputDir = strings.TrimSuffix(filepath.Base(inputFile), ".tar.gz") + "_split"
+	}
+	p, err := partition.New(partition.Options{
+		Dir:      *outputDir,
+		By:       *by,
+		Template: *template,
+		Empty:    *empty,
+		Vars:     []string{"file"},
+		Require:  []string{"advertising_id"},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// 创建输出目录
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
 		log.Fatalf("Failed to create output directory: %v", err)
 	}
 
@@ -33,14 +58,21 @@ func main() {
 	defer inFile.Close()
 
 	// 处理 tar.gz 文件
-	if err := processTarGz(inFile, outputDir); err != nil {
+	err = processTarGz(inFile, p)
+	if cerr := p.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
 		log.Fatalf("Error processing file: %v", err)
 	}
 
-	log.Printf("Successfully split files into directory: %s", outputDir)
+	if p.Dropped() > 0 {
+		log.Printf("Dropped %d rows with an empty key", p.Dropped())
+	}
+	log.Printf("Successfully split files into %d partitions in directory: %s", p.Partitions(), *outputDir)
 }
 
-func processTarGz(inFile io.Reader, outputDir string) error {
+func processTarGz(inFile io.Reader, p *partition.Partitioner) error {
 	// 创建 gzip 读取器
 	gzReader, err := gzip.NewReader(inFile)
 	if err != nil {
@@ -72,85 +104,9 @@ func processTarGz(inFile io.Reader, outputDir string) error {
 		}
 
 		// 处理 CSV 文件
-		if err := processCSV(tarReader, outputDir, filepath.Base(header.Name)); err != nil {
-			return fmt.Errorf("error processing CSV: %v", err)
-		}
-	}
-
-	return nil
-}
-
-func processCSV(reader io.Reader, outputDir, originalFilename string) error {
-	csvReader := csv.NewReader(reader)
-
-	// 读取标题行
-	headers, err := csvReader.Read()
-	if err != nil {
-		return fmt.Errorf("failed to read headers: %v", err)
-	}
-
-	// 查找列索引
-	advertisingIDIndex, countryCodeIndex := -1, -1
-	for i, header := range headers {
-		switch strings.ToLower(header) {
-		case "advertising_id":
-			advertisingIDIndex = i
-		case "country_code":
-			countryCodeIndex = i
-		}
-	}
-
-	if advertisingIDIndex == -1 || countryCodeIndex == -1 {
-		return fmt.Errorf("CSV must contain 'advertising_id' and 'country_code' columns")
-	}
-
-	// 创建按国家分组的写入器
-	countryWriters := make(map[string]*csv.Writer)
-	defer func() {
-		// 确保所有写入器都被刷新
-		for _, writer := range countryWriters {
-			writer.Flush()
-		}
-	}()
-
-	// 处理每一行数据
-	for {
-		record, err := csvReader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Printf("Warning: error reading record: %v", err)
-			continue
-		}
-
-		countryCode := record[countryCodeIndex]
-		if countryCode == "" {
-			continue
-		}
-
-		writer, exists := countryWriters[countryCode]
-		if !exists {
-			// 创建新文件
-			outputFilename := filepath.Join(outputDir, fmt.Sprintf("%s.csv", countryCode))
-			outputFile, err := os.Create(outputFilename)
-			if err != nil {
-				return fmt.Errorf("failed to create output file: %v", err)
-			}
-
-			// 创建 CSV 写入器并写入标题行
-			writer = csv.NewWriter(outputFile)
-			if err := writer.Write(headers); err != nil {
-				outputFile.Close()
-				return fmt.Errorf("failed to write headers: %v", err)
-			}
-
-			countryWriters[countryCode] = writer
-		}
-
-		// 写入记录
-		if err := writer.Write(record); err != nil {
-			log.Printf("Warning: failed to write record: %v", err)
+		name := filepath.Base(header.Name)
+		if err := p.Process(tarReader, map[string]string{"file": strings.TrimSuffix(name, filepath.Ext(name))}); err != nil {
+			return fmt.Errorf("error processing %s: %v", header.Name, err)
 		}
 	}
 
@@ -3,20 +3,30 @@ package main
 import (
 	"archive/tar"
 	"compress/gzip"
-	"encoding/csv"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"path/filepath"
+
+	"github.com/cherry77/go-learn/csv/partition"
 )
 
 func main() {
-	// 输入文件
-	inputFile := "ams_0501_0523_ip.csv.tar.gz"
+	inputFile := flag.String("in", "ams_0501_0523_ip.csv.tar.gz", "输入的 tar.gz 文件")
+	outputDir := flag.String("o", "output", "输出目录")
+	by := flag.String("by", "country_code", "分区列，多列用逗号分隔")
+	template := flag.String("template", "", "输出路径模板，如 {country_code}/{platform}.csv，占位符为 -by 的列 (默认 {列1}_{列2}.csv)")
+	empty := flag.String("empty", "unknown", "键值为空时使用的分区名；设为空字符串则丢弃这些行")
+	flag.Parse()
+
+	// 分区列和模板
+	p, err := partition.New(partition.Options{Dir: *outputDir, By: *by, Template: *template, Empty: *empty})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// 打开 tar.gz 文件
-	f, err := os.Open(inputFile)
+	f, err := os.Open(*inputFile)
 	if err != nil {
 		log.Fatalf("无法打开文件: %v", err)
 	}
@@ -42,87 +52,22 @@ func main() {
 		log.Fatalf("不是常规文件: %v", header.Name)
 	}
 
-	// 创建 CSV 读取器
-	csvr := csv.NewReader(tr)
-
-	// 读取 CSV 头部
-	headerRow, err := csvr.Read()
-	if err != nil {
-		log.Fatalf("无法读取 CSV 头部: %v", err)
-	}
-
-	// 查找 country_code 列的索引
-	countryCodeIndex := -1
-	for i, col := range headerRow {
-		if col == "country_code" {
-			countryCodeIndex = i
-			break
-		}
-	}
-	if countryCodeIndex == -1 {
-		log.Fatal("CSV 文件中没有 country_code 列")
-	}
-
 	// 创建输出目录
-	outputDir := "output"
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
 		log.Fatalf("无法创建输出目录: %v", err)
 	}
 
-	// 用于存储不同国家的 CSV 写入器
-	writers := make(map[string]*csv.Writer)
-	files := make(map[string]*os.File)
-
-	// 读取并处理 CSV 数据
-	for {
-		record, err := csvr.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Printf("无法读取 CSV 记录: %v", err)
-			continue
-		}
-
-		countryCode := record[countryCodeIndex]
-		if countryCode == "" {
-			countryCode = "unknown"
-		}
-
-		// 如果该国家的写入器不存在，则创建一个
-		if _, exists := writers[countryCode]; !exists {
-			outputFile := filepath.Join(outputDir, fmt.Sprintf("%s.csv", countryCode))
-			f, err := os.Create(outputFile)
-			if err != nil {
-				log.Printf("无法创建输出文件 %s: %v", outputFile, err)
-				continue
-			}
-			files[countryCode] = f
-			writer := csv.NewWriter(f)
-			writers[countryCode] = writer
-
-			// 写入头部
-			if err := writer.Write(headerRow); err != nil {
-				log.Printf("无法写入 CSV 头部到 %s: %v", outputFile, err)
-			}
-		}
-
-		// 写入记录
-		if err := writers[countryCode].Write(record); err != nil {
-			log.Printf("无法写入 CSV 记录到 %s.csv: %v", countryCode, err)
-		}
+	// 拆分 CSV 数据
+	err = p.Process(tr, nil)
+	if cerr := p.Close(); err == nil {
+		err = cerr
 	}
-
-	// 刷新所有写入器并关闭文件
-	for countryCode, writer := range writers {
-		writer.Flush()
-		if err := writer.Error(); err != nil {
-			log.Printf("刷新写入器 %s 时出错: %v", countryCode, err)
-		}
-		if err := files[countryCode].Close(); err != nil {
-			log.Printf("关闭文件 %s 时出错: %v", countryCode, err)
-		}
+	if err != nil {
+		log.Fatalf("拆分 %s 失败: %v", header.Name, err)
 	}
 
-	fmt.Println("CSV 文件拆分完成")
+	if p.Dropped() > 0 {
+		log.Printf("丢弃了 %d 行键值为空的记录", p.Dropped())
+	}
+	fmt.Printf("CSV 文件拆分完成，共 %d 个分区\n", p.Partitions())
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultAccountQPS = 2
+
+// accountColumn tags each row with the profile it was fetched with. It is
+// put first in -accounts exports so merged files still sort by account.
+var accountColumn = Column{Name: "account", Day: -1, value: func(row *IAARow, _ FormatOptions) string {
+	return row.account
+}}
+
+// accountResult is the outcome of one account's fetch and write.
+type accountResult struct {
+	cfg     *Config
+	rows    []*IAARow
+	elapsed time.Duration
+	file    string
+	err     error
+}
+
+// fetchAccounts fetches window for every account at once. Each account
+// has its own client and rate limiter, so a slow or throttled account does
+// not hold back the others, and a failure is recorded rather than
+// cancelling the rest.
+func fetchAccounts(ctx context.Context, cfgs []*Config, window DateRange, defaultQPS float64) []*accountResult {
+	results := make([]*accountResult, len(cfgs))
+	var wg sync.WaitGroup
+	for i, cfg := range cfgs {
+		result := &accountResult{cfg: cfg}
+		results[i] = result
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			qps := cfg.QPS
+			if qps == 0 {
+				qps = defaultQPS
+			}
+			limiter := newRateLimiter(qps, cfg.Concurrency)
+			defer limiter.Stop()
+
+			start := time.Now()
+			result.rows, result.err = newSource(cfg, limiter).Fetch(ctx, window)
+			result.elapsed = time.Since(start)
+			for _, row := range result.rows {
+				row.account = cfg.Profile
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// accountChecks are the schema and quality settings of an -accounts run.
+type accountChecks struct {
+	SchemaPath, SchemaPolicy string
+	Rules                    []*qualityRule // nil with -quality off
+	QualityPath, QualityMode string
+}
+
+// accountPath gives each account its own schema or quality file next to
+// path, e.g. mobvista_schema_eu.json, so accounts whose fields differ do
+// not keep overwriting each other's baseline.
+func accountPath(path, profile string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_" + profile + ext
+}
+
+// checkAccounts runs the schema and quality checks on each account's rows
+// separately. Drift under the fail policy or a strict quality failure
+// marks that account failed; the others are still exported. It returns
+// the columns to export: the expected ones plus any field the append
+// policy added for a healthy account.
+func checkAccounts(results []*accountResult, window DateRange, checks accountChecks) []string {
+	expected := append([]string(nil), fixedFieldOrder...)
+	for _, r := range succeededAccounts(results) {
+		columns, err := checkSchema(accountPath(checks.SchemaPath, r.cfg.Profile), checks.SchemaPolicy, window, fixedFieldOrder, r.rows)
+		if err != nil {
+			r.err = fmt.Errorf("schema check failed: %w", err)
+			continue
+		}
+		if checks.Rules != nil {
+			quality := newQualityChecker(checks.Rules, window)
+			for _, row := range r.rows {
+				quality.inspect(row)
+			}
+			fmt.Printf("Quality %s: %s\n", r.cfg.Profile, quality)
+			if err := quality.finish(accountPath(checks.QualityPath, r.cfg.Profile), checks.QualityMode); err != nil {
+				r.err = err
+				continue
+			}
+		}
+		for _, column := range columns {
+			if !contains(expected, column) {
+				expected = append(expected, column)
+			}
+		}
+	}
+	return expected
+}
+
+// succeededAccounts returns the accounts that have not failed.
+func succeededAccounts(results []*accountResult) []*accountResult {
+	var ok []*accountResult
+	for _, r := range results {
+		if r.err == nil {
+			ok = append(ok, r)
+		}
+	}
+	return ok
+}
+
+// succeeded returns the rows of every account that has not failed, in
+// account order.
+func succeeded(results []*accountResult) []*IAARow {
+	var rows []*IAARow
+	for _, r := range succeededAccounts(results) {
+		rows = append(rows, r.rows...)
+	}
+	return rows
+}
+
+// firstAccountError returns the first failure, in account order.
+func firstAccountError(results []*accountResult) error {
+	for _, r := range results {
+		if r.err != nil {
+			return r.err
+		}
+	}
+	return nil
+}
+
+// writeAccountSummary prints one line per account.
+func writeAccountSummary(w io.Writer, results []*accountResult) {
+	width := len("account")
+	for _, r := range results {
+		width = max(width, len(r.cfg.Profile))
+	}
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			fmt.Fprintf(w, "  %-*s  %-10s  FAILED  %v\n", width, r.cfg.Profile, r.cfg.ClientKey, r.err)
+			continue
+		}
+		fmt.Fprintf(w, "  %-*s  %-10s  ok      %d rows in %v", width, r.cfg.Profile, r.cfg.ClientKey, len(r.rows), r.elapsed.Round(time.Millisecond))
+		if r.file != "" {
+			fmt.Fprintf(w, " -> %s", r.file)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%d of %d accounts succeeded\n", len(results)-failed, len(results))
+}
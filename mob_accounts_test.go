@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mobvista.json")
+	config := `{"profiles": {
+		"eu": {"client_key": "1", "secret_ref": "env:EU_SECRET", "qps": 1.5},
+		"us": {"client_key": "2", "secret_ref": "env:US_SECRET", "output_dir": "us"}
+	}}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EU_SECRET", "eu-secret")
+	t.Setenv("US_SECRET", "us-secret")
+	// single-account overrides must not leak into every account
+	t.Setenv("MOB_CLIENT_KEY", "999")
+	t.Setenv("MOB_CLIENT_SECRET", "shared")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := registerConfigFlags(fs)
+	if err := fs.Parse([]string{"-config", path, "-per-page", "50"}); err != nil {
+		t.Fatal(err)
+	}
+	cfgs, err := f.resolveAccounts("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfgs) != 2 || cfgs[0].Profile != "eu" || cfgs[1].Profile != "us" {
+		t.Fatalf("Expected eu and us, got %v", cfgs)
+	}
+	eu, us := cfgs[0], cfgs[1]
+	if eu.ClientKey != "1" || eu.Secret() != "eu-secret" || eu.QPS != 1.5 || eu.PerPage != 50 {
+		t.Errorf("Unexpected eu account %v", eu)
+	}
+	if us.ClientKey != "2" || us.Secret() != "us-secret" || us.OutputDir != "us" {
+		t.Errorf("Unexpected us account %v", us)
+	}
+
+	if _, err := f.resolveAccounts("eu,asia"); err == nil || !strings.Contains(err.Error(), "asia") {
+		t.Errorf("Expected unknown profile asia to be rejected, got %v", err)
+	}
+}
+
+func TestFetchAccounts_FailureIsolated(t *testing.T) {
+	account := func(profile, secret string) *Config {
+		server := httptest.NewServer(newMockServer("10001", "mock-secret", 3, mockFaults{}, 1))
+		t.Cleanup(server.Close)
+		return &Config{
+			Profile:     profile,
+			BaseURL:     server.URL + mockPath,
+			ClientKey:   "10001",
+			PerPage:     10,
+			Concurrency: 2,
+			Timeout:     2 * time.Second,
+			secret:      secret,
+		}
+	}
+	window, err := resolveDateRange("2025-07-04", "2025-07-05", defaultDays, false, "UTC", time.Date(2025, 7, 20, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := fetchAccounts(context.Background(), []*Config{account("good", "mock-secret"), account("bad", "wrong")}, window, 100)
+	if results[0].err != nil || len(results[0].rows) != 6 {
+		t.Fatalf("Expected 6 rows from the good account, got %d (%v)", len(results[0].rows), results[0].err)
+	}
+	if !errors.Is(results[1].err, ErrAuth) {
+		t.Errorf("Expected ErrAuth from the bad account, got %v", results[1].err)
+	}
+	rows := succeeded(results)
+	if len(rows) != 6 || accountColumn.Value(rows[0], defaultFormatOptions) != "good" {
+		t.Errorf("Expected 6 rows tagged good, got %d", len(rows))
+	}
+	if !errors.Is(firstAccountError(results), ErrAuth) {
+		t.Errorf("Expected the run to report the failure")
+	}
+
+	var summary bytes.Buffer
+	writeAccountSummary(&summary, results)
+	if out := summary.String(); !strings.Contains(out, "bad ") || !strings.Contains(out, "FAILED") || !strings.Contains(out, "1 of 2 accounts succeeded") {
+		t.Errorf("Unexpected summary:\n%s", out)
+	}
+}
+
+func TestCheckAccounts_FailureIsolated(t *testing.T) {
+	dir := t.TempDir()
+	// one row with every expected field; install as given
+	result := func(profile, install string) *accountResult {
+		fields := make([]string, len(fixedFieldOrder))
+		for i, name := range fixedFieldOrder {
+			value := "0.5"
+			switch {
+			case textColumns[name]:
+				value = `"x"`
+			case name == "date":
+				value = `"2025-07-04"`
+			case name == "install":
+				value = install
+			}
+			fields[i] = fmt.Sprintf("%q: %s", name, value)
+		}
+		row := &IAARow{}
+		if err := json.Unmarshal([]byte("{"+strings.Join(fields, ", ")+"}"), row); err != nil {
+			t.Fatal(err)
+		}
+		return &accountResult{cfg: &Config{Profile: profile}, rows: []*IAARow{row}}
+	}
+	good := "100"
+	rules, err := loadQualityRules("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := accountChecks{
+		SchemaPath:   filepath.Join(dir, defaultSchemaFile),
+		SchemaPolicy: schemaFail,
+		Rules:        rules,
+		QualityPath:  filepath.Join(dir, defaultQualityReport),
+		QualityMode:  qualityStrict,
+	}
+	window := schemaWindow(t)
+	checkAccounts([]*accountResult{result("eu", good), result("us", good), result("asia", good)}, window, checks)
+
+	// us now sends install as a string, asia a negative install count
+	results := []*accountResult{
+		result("eu", good),
+		result("us", `"100"`),
+		result("asia", "-5"),
+	}
+	checkAccounts(results, window, checks)
+	if results[0].err != nil {
+		t.Errorf("Expected eu to pass, got %v", results[0].err)
+	}
+	if !errors.Is(results[1].err, ErrSchemaDrift) {
+		t.Errorf("Expected schema drift for us, got %v", results[1].err)
+	}
+	if !errors.Is(results[2].err, ErrQuality) {
+		t.Errorf("Expected a quality failure for asia, got %v", results[2].err)
+	}
+	if rows := succeeded(results); len(rows) != 1 {
+		t.Errorf("Expected only eu's row to be exported, got %d", len(rows))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "mobvista_schema_eu.json")); err != nil {
+		t.Errorf("Expected a schema file per account: %v", err)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Threshold methods for -method.
+const (
+	alertPct = "pct" // |value - mean| / mean
+	alertZ   = "z"   // |value - mean| / stddev
+)
+
+// Alert kinds.
+const (
+	alertDrop    = "drop"
+	alertSpike   = "spike"
+	alertZero    = "zero"    // went to zero from a non-zero baseline
+	alertMissing = "missing" // no row on the checked date
+)
+
+const (
+	defaultAlertMetrics = "install,impressions,d0_roas"
+	defaultAlertWindow  = 7
+	defaultWebhookWait  = 10 * time.Second
+)
+
+// alertOptions control anomaly detection.
+type alertOptions struct {
+	Date       string   // checked date; default the latest in the data
+	Metrics    []string // columns checked
+	Window     int      // days before Date making up the baseline
+	MinHistory int      // baseline days needed before a series is checked
+	Method     string
+	Threshold  float64 // fraction for pct, standard deviations for z
+	MinBase    float64 // ignore series whose baseline mean is below this
+	Direction  string  // both, drop or spike
+}
+
+// alert is one anomalous metric of one series on the checked date.
+type alert struct {
+	diffKey
+	Metric   string   `json:"metric"`
+	Kind     string   `json:"kind"`
+	Value    *float64 `json:"value"` // nil when missing
+	Baseline float64  `json:"baseline"`
+	StdDev   float64  `json:"stddev"`
+	Change   *float64 `json:"change,omitempty"` // relative to the baseline
+	Z        *float64 `json:"z,omitempty"`
+	History  int      `json:"history"`
+}
+
+func (a alert) String() string {
+	base := fmt.Sprintf("baseline %.4g ± %.4g over %d days", a.Baseline, a.StdDev, a.History)
+	if a.Z != nil {
+		base += fmt.Sprintf(", z %+.1f", *a.Z)
+	}
+	switch a.Kind {
+	case alertMissing:
+		return fmt.Sprintf("%s: %s missing (%s)", a.diffKey, a.Metric, base)
+	case alertZero:
+		return fmt.Sprintf("%s: %s dropped to zero (%s)", a.diffKey, a.Metric, base)
+	}
+	verb := "dropped"
+	if a.Kind == alertSpike {
+		verb = "spiked"
+	}
+	if a.Change == nil { // zero baseline: no relative change
+		return fmt.Sprintf("%s: %s %s from 0 to %.4g (%s)", a.diffKey, a.Metric, verb, *a.Value, base)
+	}
+	return fmt.Sprintf("%s: %s %s %+.1f%% to %.4g (%s)", a.diffKey, a.Metric, verb, *a.Change*100, *a.Value, base)
+}
+
+// alertReport is what -o and -webhook receive.
+type alertReport struct {
+	Date      string  `json:"date"`
+	CheckedAt string  `json:"checked_at"`
+	Method    string  `json:"method"`
+	Threshold float64 `json:"threshold"`
+	Window    int     `json:"window"`
+	Series    int     `json:"series"`
+	Alerts    []alert `json:"alerts"`
+}
+
+// alertSeries holds one channel/offer's values by date and metric, per
+// account in a merged -accounts export. A blank cell is not a value.
+type alertSeries struct {
+	account, channelID, offerID string
+	values                      map[string]map[string]float64
+}
+
+// loadAlertSeries reads exports into series. A row in a later file
+// replaces the same key from an earlier one, so a master file and the
+// latest daily export can be given together.
+func loadAlertSeries(paths []string, metrics []string) (map[string]*alertSeries, error) {
+	series := make(map[string]*alertSeries)
+	for _, path := range paths {
+		t, err := readExportTable(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, metric := range metrics {
+			if _, ok := t.index[metric]; !ok {
+				return nil, fmt.Errorf("%s: no %s column", path, metric)
+			}
+		}
+		for key, record := range t.rows {
+			k := t.keys[key]
+			id := k.Account + "\x1f" + k.ChannelID + "\x1f" + k.OfferID
+			s := series[id]
+			if s == nil {
+				s = &alertSeries{account: k.Account, channelID: k.ChannelID, offerID: k.OfferID, values: make(map[string]map[string]float64)}
+				series[id] = s
+			}
+			values := make(map[string]float64, len(metrics))
+			for _, metric := range metrics {
+				if v, err := strconv.ParseFloat(t.value(record, metric), 64); err == nil {
+					values[metric] = v
+				}
+			}
+			s.values[k.Date] = values
+		}
+	}
+	return series, nil
+}
+
+// detectAnomalies compares each series on opts.Date with its own values
+// over the opts.Window days before.
+func detectAnomalies(series map[string]*alertSeries, opts alertOptions) (*alertReport, error) {
+	if opts.Date == "" {
+		for _, s := range series {
+			for date := range s.values {
+				opts.Date = max(opts.Date, date)
+			}
+		}
+	}
+	day, err := parseDate(opts.Date, time.UTC)
+	if err != nil {
+		return nil, err
+	}
+	report := &alertReport{Date: opts.Date, Method: opts.Method, Threshold: opts.Threshold, Window: opts.Window, Alerts: []alert{}}
+
+	for _, id := range sortedKeys(series) {
+		s := series[id]
+		report.Series++
+		current, present := s.values[opts.Date]
+		for _, metric := range opts.Metrics {
+			var history []float64
+			for i := opts.Window; i >= 1; i-- {
+				if v, ok := s.values[day.AddDate(0, 0, -i).Format(dateLayout)][metric]; ok {
+					history = append(history, v)
+				}
+			}
+			if len(history) < opts.MinHistory || len(history) == 0 {
+				continue
+			}
+			mean, stddev := meanStdDev(history)
+			if mean < opts.MinBase {
+				continue
+			}
+			a := alert{
+				diffKey:  diffKey{Account: s.account, Date: opts.Date, ChannelID: s.channelID, OfferID: s.offerID},
+				Metric:   metric,
+				Baseline: mean,
+				StdDev:   stddev,
+				History:  len(history),
+			}
+			value, ok := current[metric]
+			if !present || !ok {
+				if opts.Direction != alertSpike && mean > 0 {
+					a.Kind = alertMissing
+					report.Alerts = append(report.Alerts, a)
+				}
+				continue
+			}
+			a.Value = &value
+			if stddev > 0 {
+				z := (value - mean) / stddev
+				a.Z = &z
+			}
+			if mean != 0 {
+				change := (value - mean) / math.Abs(mean)
+				a.Change = &change
+			}
+			if a.Kind = anomalyKind(a, opts); a.Kind != "" {
+				report.Alerts = append(report.Alerts, a)
+			}
+		}
+	}
+	return report, nil
+}
+
+// anomalyKind returns the kind of anomaly a is, or "". A flat baseline has
+// no spread to measure z against, so z falls back to the relative change
+// with the same threshold.
+func anomalyKind(a alert, opts alertOptions) string {
+	value := *a.Value
+	if value == 0 && a.Baseline > 0 {
+		if opts.Direction == alertSpike {
+			return ""
+		}
+		return alertZero
+	}
+	var score float64
+	switch {
+	case opts.Method == alertZ && a.Z != nil:
+		score = *a.Z
+	case a.Change != nil:
+		score = *a.Change
+	default: // baseline of zero: any value is a spike
+		score = math.Copysign(math.Inf(1), value)
+	}
+	switch {
+	case score <= -opts.Threshold && opts.Direction != alertSpike:
+		return alertDrop
+	case score >= opts.Threshold && opts.Direction != alertDrop:
+		return alertSpike
+	}
+	return ""
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	var ss float64
+	for _, v := range values {
+		ss += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(ss / float64(len(values)-1))
+}
+
+// postWebhook sends report as JSON. Any non-2xx response is an error.
+func postWebhook(ctx context.Context, url string, report *alertReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func writeAlertJSON(w io.Writer, report *alertReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// runAlert checks the latest day of exported data for anomalies.
+func runAlert(args []string) {
+	fs := flag.NewFlagSet("alert", flag.ExitOnError)
+	opts := alertOptions{}
+	fs.StringVar(&opts.Date, "date", "", "date to check, YYYY-MM-DD (default the latest date in the data)")
+	metrics := fs.String("metrics", defaultAlertMetrics, "comma-separated columns to check")
+	fs.IntVar(&opts.Window, "window", defaultAlertWindow, "days before -date that make up the baseline")
+	fs.IntVar(&opts.MinHistory, "min-history", 3, "baseline days a series needs before it is checked")
+	fs.StringVar(&opts.Method, "method", alertPct, "threshold method: pct (relative change) or z (standard deviations)")
+	fs.Float64Var(&opts.Threshold, "threshold", 0, "alert at this change: a fraction for pct (default 0.5), deviations for z (default 3)")
+	fs.Float64Var(&opts.MinBase, "min-base", 0, "skip series whose baseline mean is below this")
+	fs.StringVar(&opts.Direction, "direction", "both", "alert on both, drop or spike")
+	output := fs.String("o", "", "also write the alerts as JSON to this file")
+	webhook := fs.String("webhook", "", "POST the alerts as JSON to this URL")
+	webhookTimeout := fs.Duration("webhook-timeout", defaultWebhookWait, "webhook request timeout")
+	quiet := fs.Bool("quiet", false, "skip the webhook when there are no alerts")
+	exitCodeFlag := fs.Bool("exit-code", false, "exit with status 1 when there are alerts")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s alert [flags] EXPORT...\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	switch opts.Method {
+	case alertPct, alertZ:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown method %q (want pct or z)\n", opts.Method)
+		os.Exit(2)
+	}
+	switch opts.Direction {
+	case "both", alertDrop, alertSpike:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown direction %q (want both, drop or spike)\n", opts.Direction)
+		os.Exit(2)
+	}
+	thresholdSet := false
+	fs.Visit(func(fl *flag.Flag) { thresholdSet = thresholdSet || fl.Name == "threshold" })
+	if !thresholdSet {
+		opts.Threshold = 0.5
+		if opts.Method == alertZ {
+			opts.Threshold = 3
+		}
+	}
+	opts.Metrics = splitList(*metrics)
+	if fs.NArg() == 0 || len(opts.Metrics) == 0 || opts.Window < 1 || opts.MinHistory < 1 || opts.Threshold < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	series, err := loadAlertSeries(fs.Args(), opts.Metrics)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	report, err := detectAnomalies(series, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	report.CheckedAt = time.Now().Format(time.RFC3339)
+
+	for _, a := range report.Alerts {
+		fmt.Println(a)
+	}
+	fmt.Printf("%s: %d alerts across %d series\n", report.Date, len(report.Alerts), report.Series)
+
+	failed := false
+	if *output != "" {
+		if err := writeFileAtomic(*output, func(w io.Writer) error { return writeAlertJSON(w, report) }); err != nil {
+			fmt.Fprintln(os.Stderr, "writing alerts failed:", err)
+			failed = true
+		}
+	}
+	if *webhook != "" && (len(report.Alerts) > 0 || !*quiet) {
+		ctx, cancel := context.WithTimeout(context.Background(), *webhookTimeout)
+		err := postWebhook(ctx, *webhook, report)
+		cancel()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "webhook failed:", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+	if *exitCodeFlag && len(report.Alerts) > 0 {
+		os.Exit(1)
+	}
+}
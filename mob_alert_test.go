@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// offer 10 halves its installs, offer 11 loses all impressions, offer 12
+// creeps up 5% on a tight baseline and offer 13 has no row on the last day.
+const alertHistory = "date,channel_id,offer_id,install,impressions\n" +
+	"2025-07-01,1,10,100,1000\n2025-07-02,1,10,102,1000\n2025-07-03,1,10,98,1000\n2025-07-04,1,10,101,1000\n2025-07-05,1,10,99,1000\n" +
+	"2025-07-01,1,11,10,500\n2025-07-02,1,11,10,520\n2025-07-03,1,11,10,480\n2025-07-04,1,11,10,500\n2025-07-05,1,11,10,500\n" +
+	"2025-07-01,1,12,100,10\n2025-07-02,1,12,101,10\n2025-07-03,1,12,99,10\n2025-07-04,1,12,100,10\n2025-07-05,1,12,100,10\n" +
+	"2025-07-03,1,13,20,200\n2025-07-04,1,13,20,200\n2025-07-05,1,13,20,200\n"
+
+const alertLatest = "date,channel_id,offer_id,install,impressions\n" +
+	"2025-07-06,1,10,40,1000\n" +
+	"2025-07-06,1,11,10,0\n" +
+	"2025-07-06,1,12,105,10\n"
+
+func alertFixture(t *testing.T) map[string]*alertSeries {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for name, content := range map[string]string{"master.csv": alertHistory, "latest.csv": alertLatest} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	series, err := loadAlertSeries(paths, []string{"install", "impressions"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return series
+}
+
+func alertSummary(report *alertReport) string {
+	var parts []string
+	for _, a := range report.Alerts {
+		parts = append(parts, a.OfferID+" "+a.Metric+" "+a.Kind)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestDetectAnomalies_Pct(t *testing.T) {
+	opts := alertOptions{Metrics: []string{"install", "impressions"}, Window: 7, MinHistory: 3, Method: alertPct, Threshold: 0.5, Direction: "both"}
+	report, err := detectAnomalies(alertFixture(t), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Date != "2025-07-06" || report.Series != 4 {
+		t.Errorf("Expected 4 series on 2025-07-06, got %d on %s", report.Series, report.Date)
+	}
+	want := "10 install drop, 11 impressions zero, 13 install missing, 13 impressions missing"
+	if got := alertSummary(report); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if a := report.Alerts[0]; *a.Change != -0.6 || a.Baseline != 100 || a.History != 5 {
+		t.Errorf("Expected -60%% against 100 over 5 days, got %v", a)
+	}
+
+	opts.Direction = alertSpike
+	if report, _ = detectAnomalies(alertFixture(t), opts); len(report.Alerts) != 0 {
+		t.Errorf("Expected no spikes, got %s", alertSummary(report))
+	}
+}
+
+func TestDetectAnomalies_Z(t *testing.T) {
+	opts := alertOptions{Metrics: []string{"install"}, Window: 7, MinHistory: 3, Method: alertZ, Threshold: 3, Direction: "both", MinBase: 50}
+	report, err := detectAnomalies(alertFixture(t), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a 5% rise is far outside a baseline with a standard deviation of 0.7
+	if got, want := alertSummary(report), "10 install drop, 12 install spike"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestDetectAnomalies_ZeroBaseline(t *testing.T) {
+	series := map[string]*alertSeries{"1\x1f20": {channelID: "1", offerID: "20", values: map[string]map[string]float64{
+		"2025-07-01": {"impressions": 0}, "2025-07-02": {"impressions": 0}, "2025-07-03": {"impressions": 0},
+		"2025-07-04": {"impressions": 300},
+	}}}
+	opts := alertOptions{Metrics: []string{"impressions"}, Window: 7, MinHistory: 3, Method: alertPct, Threshold: 0.5, Direction: "both"}
+	report, err := detectAnomalies(series, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Alerts) != 1 || report.Alerts[0].Kind != alertSpike || report.Alerts[0].Change != nil {
+		t.Fatalf("Expected one spike without a relative change, got %s", alertSummary(report))
+	}
+	if got := report.Alerts[0].String(); !strings.Contains(got, "impressions spiked from 0 to 300") || strings.Contains(got, "PANIC") {
+		t.Errorf("Unexpected alert text %q", got)
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	var got alertReport
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected %s with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer receiver.Close()
+
+	opts := alertOptions{Metrics: []string{"install", "impressions"}, Window: 7, MinHistory: 3, Method: alertPct, Threshold: 0.5, Direction: "both"}
+	report, _ := detectAnomalies(alertFixture(t), opts)
+	if err := postWebhook(context.Background(), receiver.URL, report); err != nil {
+		t.Fatal(err)
+	}
+	if got.Date != "2025-07-06" || len(got.Alerts) != 4 || got.Alerts[0].Value == nil || *got.Alerts[0].Value != 40 {
+		t.Errorf("Unexpected webhook payload %+v", got)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	if err := postWebhook(context.Background(), failing.URL, report); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Expected HTTP 502 error, got %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWindowDays       = 7
+	defaultBackfillParallel = 2
+	defaultBackfillQPS      = 2
+	defaultWindowRetries    = 2
+	defaultPartitionPattern = "Mobvista_IAA_{date}.{ext}"
+	checkpointFile          = ".backfill_checkpoint.json"
+)
+
+// backfillCheckpoint records which dates have been written. Progress is
+// kept per date rather than per window so a rerun resumes correctly even
+// with a different -window-days. Output records the settings the files
+// were written with; a rerun with other settings starts over.
+type backfillCheckpoint struct {
+	Start          string         `json:"start"`
+	End            string         `json:"end"`
+	Output         backfillOutput `json:"output"`
+	CompletedDates []string       `json:"completed_dates"`
+	UpdatedAt      string         `json:"updated_at"`
+
+	path      string
+	mu        sync.Mutex
+	completed map[string]bool
+}
+
+// backfillOutput is everything that decides what a partition file
+// contains, apart from the data itself.
+type backfillOutput struct {
+	Format   string `json:"format"`
+	BOM      bool   `json:"bom,omitempty"`
+	Columns  string `json:"columns"`
+	Pattern  string `json:"pattern"`
+	Immature string `json:"immature,omitempty"`
+	Maturity bool   `json:"maturity_columns,omitempty"`
+}
+
+// loadCheckpoint reads the checkpoint at path. Dates completed with other
+// output settings do not count as done, so those files are rewritten.
+func loadCheckpoint(path string, output backfillOutput) (*backfillCheckpoint, error) {
+	cp := &backfillCheckpoint{path: path, Output: output, completed: make(map[string]bool)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("parsing checkpoint %s: %v", path, err)
+	}
+	if cp.Output != output {
+		if len(cp.CompletedDates) > 0 {
+			fmt.Printf("Checkpoint %s was written with %+v; starting over with %+v\n", path, cp.Output, output)
+		}
+		cp.Output, cp.CompletedDates = output, nil
+	}
+	for _, date := range cp.CompletedDates {
+		cp.completed[date] = true
+	}
+	return cp, nil
+}
+
+func (cp *backfillCheckpoint) done(date string) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.completed[date]
+}
+
+// markDone records dates as written and persists the checkpoint.
+func (cp *backfillCheckpoint) markDone(dates []string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	for _, date := range dates {
+		cp.completed[date] = true
+	}
+	cp.CompletedDates = cp.CompletedDates[:0]
+	for date := range cp.completed {
+		cp.CompletedDates = append(cp.CompletedDates, date)
+	}
+	sort.Strings(cp.CompletedDates)
+	cp.UpdatedAt = time.Now().Format(time.RFC3339)
+	return writeFileAtomic(cp.path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cp)
+	})
+}
+
+// splitWindows groups the dates still to do into contiguous windows of at
+// most size days. Completed dates break a window so they are not fetched
+// again.
+func splitWindows(r DateRange, size int, done func(string) bool) []DateRange {
+	var windows []DateRange
+	open := false
+	for d := r.Start; !d.After(r.End); d = d.AddDate(0, 0, 1) {
+		if done(d.Format(dateLayout)) {
+			open = false
+			continue
+		}
+		if !open || windows[len(windows)-1].Days() >= size {
+			windows = append(windows, DateRange{Start: d, End: d, Location: r.Location})
+			open = true
+			continue
+		}
+		windows[len(windows)-1].End = d
+	}
+	return windows
+}
+
+// groupByDate splits a window's rows per date. Rows dated outside the
+// window have no partition to go to; they are dropped and counted.
+func groupByDate(w DateRange, rows []*IAARow) (map[string][]*IAARow, int) {
+	byDate := make(map[string][]*IAARow, w.Days())
+	for _, date := range w.Dates() {
+		byDate[date] = nil
+	}
+	dropped := 0
+	for _, row := range rows {
+		date := string(row.Date)
+		if _, ok := byDate[date]; !ok {
+			dropped++
+			continue
+		}
+		byDate[date] = append(byDate[date], row)
+	}
+	return byDate, dropped
+}
+
+type windowResult struct {
+	window DateRange
+	rows   int
+	err    error
+}
+
+// runBackfill fetches a long date range as a set of smaller windows, a few
+// at a time under one shared rate limit, and writes one file per date.
+func runBackfill(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	configFlags := registerConfigFlags(fs)
+	dateFlags := registerDateFlags(fs)
+	maturityFlags := registerMaturityFlags(fs)
+	formatOpts := defaultFormatOptions
+	exportOpts := ExportOptions{}
+	fs.StringVar(&exportOpts.Format, "format", "csv", "output format: "+formatNames())
+	fs.BoolVar(&exportOpts.BOM, "bom", false, "start CSV/TSV output with a UTF-8 BOM")
+	windowDays := fs.Int("window-days", defaultWindowDays, "days per API request window")
+	parallel := fs.Int("parallel", defaultBackfillParallel, "windows fetched at the same time")
+	qps := fs.Float64("qps", defaultBackfillQPS, "requests per second shared by all windows")
+	windowRetries := fs.Int("window-retries", defaultWindowRetries, "times a failed window is retried before giving up")
+	partitionDir := fs.String("partition-dir", "", "directory for per-date files (default <output-dir>/partitions)")
+	partitionPattern := fs.String("partition-pattern", defaultPartitionPattern, "per-date filename; placeholders: {date} {ext} {format}")
+	columnsFlag := fs.String("columns", "fixed", "column layout: fixed, sorted or file:<path>; sorted is worked out per window")
+	fs.Parse(args)
+
+	if _, ok := outputFormats[exportOpts.Format]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q (want one of %s)\n", exportOpts.Format, formatNames())
+		os.Exit(2)
+	}
+	layout, err := parseColumnLayout(*columnsFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "columns:", err)
+		os.Exit(2)
+	}
+	if *windowDays < 1 || *parallel < 1 || *qps <= 0 || *windowRetries < 0 {
+		fmt.Fprintln(os.Stderr, "window-days, parallel and qps must be positive and window-retries not negative")
+		os.Exit(2)
+	}
+	window, err := dateFlags.resolve(time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "date range:", err)
+		os.Exit(2)
+	}
+	maturity, err := maturityFlags.resolve(window.Location, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "maturity:", err)
+		os.Exit(2)
+	}
+	cfg, err := configFlags.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(2)
+	}
+	*partitionDir = firstNonEmpty(*partitionDir, filepath.Join(cfg.OutputDir, "partitions"))
+
+	checkpoint, err := loadCheckpoint(filepath.Join(*partitionDir, checkpointFile), backfillOutput{
+		Format:   exportOpts.Format,
+		BOM:      exportOpts.BOM,
+		Columns:  *columnsFlag,
+		Pattern:  *partitionPattern,
+		Immature: maturityFlags.policy,
+		Maturity: maturityFlags.columns,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "checkpoint:", err)
+		os.Exit(1)
+	}
+	checkpoint.Start, checkpoint.End = window.StartDate(), window.EndDate()
+
+	windows := splitWindows(window, *windowDays, checkpoint.done)
+	fmt.Printf("Backfilling %s: %d windows to fetch, %d dates already done\n",
+		window, len(windows), window.Days()-countDates(windows))
+
+	limiter := newRateLimiter(*qps, *parallel)
+	defer limiter.Stop()
+	source := newSource(cfg, limiter)
+
+	writePartitions := func(w DateRange, rows []*IAARow) error {
+		columns := maturity.apply(layout.resolve(fixedFieldOrder, rows))
+		byDate, dropped := groupByDate(w, rows)
+		if dropped > 0 {
+			fmt.Fprintf(os.Stderr, "Window %s: dropped %d rows dated outside the window\n", w, dropped)
+		}
+		// Every date gets a file, even an empty one, so a missing
+		// partition always means "not fetched" rather than "no data".
+		for _, date := range w.Dates() {
+			name, err := expandPattern(*partitionPattern, map[string]string{
+				"date":   date,
+				"ext":    outputFormats[exportOpts.Format],
+				"format": exportOpts.Format,
+			})
+			if err != nil {
+				return err
+			}
+			if err := exportFile(filepath.Join(*partitionDir, name), columns, byDate[date], formatOpts, exportOpts); err != nil {
+				return err
+			}
+		}
+		return checkpoint.markDone(w.Dates())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	windowChan := make(chan DateRange)
+	results := make(chan windowResult)
+	var wg sync.WaitGroup
+	for i := 0; i < *parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := range windowChan {
+				var rows []*IAARow
+				var err error
+				for attempt := 0; attempt <= *windowRetries; attempt++ {
+					if attempt > 0 {
+						fmt.Printf("Retrying window %s (attempt %d): %v\n", w, attempt+1, err)
+						select {
+						case <-time.After(time.Duration(attempt) * 5 * time.Second):
+						case <-ctx.Done():
+						}
+						if ctx.Err() != nil {
+							err = ctx.Err()
+							break
+						}
+					}
+					if rows, err = source.Fetch(ctx, w); err == nil {
+						err = writePartitions(w, rows)
+					}
+					if err == nil || errors.Is(err, ErrAuth) {
+						break
+					}
+				}
+				results <- windowResult{window: w, rows: len(rows), err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, w := range windows {
+			windowChan <- w
+		}
+		close(windowChan)
+		wg.Wait()
+		close(results)
+	}()
+
+	var failed []windowResult
+	done := 0
+	for result := range results {
+		if result.err != nil {
+			failed = append(failed, result)
+			fmt.Fprintf(os.Stderr, "Window %s failed: %v\n", result.window, result.err)
+			continue
+		}
+		done++
+		fmt.Printf("Window %s done: %d rows (%d/%d)\n", result.window, result.rows, done, len(windows))
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d windows failed; rerun the same command to resume\n", len(failed), len(windows))
+		os.Exit(exitCode(failed[0].err))
+	}
+	fmt.Printf("Backfill of %s complete in %s\n", window, *partitionDir)
+}
+
+func countDates(windows []DateRange) int {
+	n := 0
+	for _, w := range windows {
+		n += w.Days()
+	}
+	return n
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func backfillRange(start, end string) DateRange {
+	s, _ := parseDate(start, time.UTC)
+	e, _ := parseDate(end, time.UTC)
+	return DateRange{Start: s, End: e, Location: time.UTC}
+}
+
+func windowList(windows []DateRange) string {
+	parts := make([]string, len(windows))
+	for i, w := range windows {
+		parts[i] = w.StartDate()[5:] + ".." + w.EndDate()[5:]
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestSplitWindows(t *testing.T) {
+	doneSet := func(dates ...string) func(string) bool {
+		return func(date string) bool { return contains(dates, date) }
+	}
+	cases := []struct {
+		start, end string
+		size       int
+		done       func(string) bool
+		want       string
+	}{
+		{"2025-07-01", "2025-07-01", 7, doneSet(), "07-01..07-01"},
+		{"2025-07-01", "2025-07-07", 7, doneSet(), "07-01..07-07"},
+		{"2025-07-01", "2025-07-08", 7, doneSet(), "07-01..07-07 07-08..07-08"},
+		{"2025-07-01", "2025-07-05", 1, doneSet(), "07-01..07-01 07-02..07-02 07-03..07-03 07-04..07-04 07-05..07-05"},
+		// a completed date splits a window; completed edges are skipped
+		{"2025-07-01", "2025-07-07", 7, doneSet("2025-07-04"), "07-01..07-03 07-05..07-07"},
+		{"2025-07-01", "2025-07-07", 7, doneSet("2025-07-01", "2025-07-07"), "07-02..07-06"},
+		{"2025-07-01", "2025-07-02", 7, doneSet("2025-07-01", "2025-07-02"), ""},
+		// month end
+		{"2025-06-29", "2025-07-02", 3, doneSet(), "06-29..07-01 07-02..07-02"},
+	}
+	for _, c := range cases {
+		windows := splitWindows(backfillRange(c.start, c.end), c.size, c.done)
+		if got := windowList(windows); got != c.want {
+			t.Errorf("%s..%s by %d: expected %q, got %q", c.start, c.end, c.size, c.want, got)
+		}
+	}
+}
+
+func TestCheckpoint_Resume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), checkpointFile)
+	output := backfillOutput{Format: "csv", Columns: "fixed", Pattern: defaultPartitionPattern}
+	cp, err := loadCheckpoint(path, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.markDone([]string{"2025-07-02", "2025-07-03"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a rerun only fetches what is left, even with another window size
+	cp, err = loadCheckpoint(path, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	window := backfillRange("2025-07-01", "2025-07-05")
+	if got := windowList(splitWindows(window, 7, cp.done)); got != "07-01..07-01 07-04..07-05" {
+		t.Errorf("Unexpected resumed windows %q", got)
+	}
+
+	// files written as CSV do not count for a TSV run
+	tsv := output
+	tsv.Format = "tsv"
+	cp, err = loadCheckpoint(path, tsv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := windowList(splitWindows(window, 7, cp.done)); got != "07-01..07-05" {
+		t.Errorf("Expected a change of format to start over, got %q", got)
+	}
+	cp.markDone([]string{"2025-07-01"})
+	if cp, _ := loadCheckpoint(path, tsv); cp.Output != tsv || fmt.Sprint(cp.CompletedDates) != "[2025-07-01]" {
+		t.Errorf("Expected the checkpoint rewritten for the new settings, got %+v", cp)
+	}
+}
+
+func TestGroupByDate(t *testing.T) {
+	rows := []*IAARow{
+		decodeRow(t, `{"date":"2025-07-01","offer_id":1}`),
+		decodeRow(t, `{"date":"2025-07-02","offer_id":1}`),
+		decodeRow(t, `{"date":"2025-07-01","offer_id":2}`),
+		decodeRow(t, `{"date":"2025-06-30","offer_id":1}`),
+		decodeRow(t, `{"date":"","offer_id":1}`),
+	}
+	byDate, dropped := groupByDate(backfillRange("2025-07-01", "2025-07-03"), rows)
+	if dropped != 2 {
+		t.Errorf("Expected 2 rows outside the window dropped, got %d", dropped)
+	}
+	if len(byDate) != 3 || len(byDate["2025-07-01"]) != 2 || len(byDate["2025-07-02"]) != 1 || len(byDate["2025-07-03"]) != 0 {
+		t.Errorf("Unexpected grouping %v", byDate)
+	}
+}
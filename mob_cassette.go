@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cassettes. With -record every API exchange of a run is saved to a
+// directory: request metadata with credentials redacted in cassette.json,
+// and each raw response body in its own file. With -replay the same
+// directory answers the requests instead of the network, so a bad export
+// can be reproduced and attached to a bug report.
+const cassetteIndex = "cassette.json"
+
+// volatileParams change on every request and are left out when matching.
+var volatileParams = []string{paramTime, paramToken, paramSecret}
+
+// ErrNotRecorded is returned in replay mode for a request the cassette has
+// no response for.
+var ErrNotRecorded = errors.New("request not in cassette")
+
+// interaction is one recorded request and its response.
+type interaction struct {
+	Seq            int               `json:"seq"`
+	Key            string            `json:"key"`
+	Method         string            `json:"method"`
+	URL            string            `json:"url"` // redacted
+	RequestHeaders map[string]string `json:"request_headers,omitempty"`
+	RecordedAt     string            `json:"recorded_at"`
+	DurationMS     int64             `json:"duration_ms"`
+	Status         int               `json:"status,omitempty"`
+	Headers        http.Header       `json:"headers,omitempty"`
+	BodyFile       string            `json:"body_file,omitempty"`
+	Error          string            `json:"error,omitempty"` // transport error instead of a response
+}
+
+type cassetteFile struct {
+	CreatedAt    string         `json:"created_at"`
+	Interactions []*interaction `json:"interactions"`
+}
+
+// cassette is a RoundTripper that records to or replays from dir.
+type cassette struct {
+	dir    string
+	replay bool
+	next   http.RoundTripper // recording only
+
+	mu     sync.Mutex
+	file   cassetteFile
+	served map[string]int // replay: responses already served per key
+}
+
+type cassetteKey struct {
+	dir    string
+	replay bool
+}
+
+var (
+	cassettesMu sync.Mutex
+	cassettes   = make(map[cassetteKey]*cassette)
+)
+
+// openCassette returns the cassette for dir, shared by every client of the
+// run so concurrent pages and accounts land in one index.
+func openCassette(dir string, replay bool) (*cassette, error) {
+	cassettesMu.Lock()
+	defer cassettesMu.Unlock()
+	key := cassetteKey{dir, replay}
+	if c, ok := cassettes[key]; ok {
+		return c, nil
+	}
+	c := &cassette{dir: dir, replay: replay, next: http.DefaultTransport, served: make(map[string]int)}
+	if replay {
+		data, err := os.ReadFile(filepath.Join(dir, cassetteIndex))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &c.file); err != nil {
+			return nil, fmt.Errorf("parsing cassette %s: %v", dir, err)
+		}
+	} else {
+		c.file.CreatedAt = time.Now().Format(time.RFC3339)
+		c.file.Interactions = []*interaction{}
+		if err := c.save(); err != nil {
+			return nil, err
+		}
+	}
+	cassettes[key] = c
+	return c, nil
+}
+
+// requestKey identifies a request independently of signing: the method and
+// URL with the per-request time and token dropped and the query sorted.
+// With the token gone the key holds no credentials and is stored as is.
+func requestKey(req *http.Request) string {
+	u := *req.URL
+	q := u.Query()
+	for _, p := range volatileParams {
+		q.Del(p)
+	}
+	u.RawQuery = q.Encode()
+	return req.Method + " " + u.String()
+}
+
+func (c *cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.replay {
+		return c.play(req)
+	}
+	return c.record(req)
+}
+
+// record reads the whole body so it can be saved; recording is for
+// reproducing problems, not for exports too big to hold in memory.
+func (c *cassette) record(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.next.RoundTrip(req)
+	var body []byte
+	if err == nil {
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	it := &interaction{
+		Seq:        len(c.file.Interactions) + 1,
+		Key:        requestKey(req),
+		Method:     req.Method,
+		URL:        redactURL(req.URL.String()),
+		RecordedAt: start.Format(time.RFC3339Nano),
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	for name := range req.Header {
+		if it.RequestHeaders == nil {
+			it.RequestHeaders = make(map[string]string)
+		}
+		it.RequestHeaders[name] = req.Header.Get(name)
+		if name == "Authorization" {
+			it.RequestHeaders[name] = "REDACTED"
+		}
+	}
+	if err != nil {
+		it.Error = err.Error()
+	} else {
+		it.Status = resp.StatusCode
+		it.Headers = resp.Header
+		it.BodyFile = fmt.Sprintf("%04d.body", it.Seq)
+		if werr := os.WriteFile(filepath.Join(c.dir, it.BodyFile), body, 0644); werr != nil {
+			return nil, fmt.Errorf("recording response: %v", werr)
+		}
+	}
+	c.file.Interactions = append(c.file.Interactions, it)
+	if serr := c.save(); serr != nil {
+		return nil, fmt.Errorf("recording response: %v", serr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// play serves the recorded responses for a request in the order they were
+// recorded; once they run out the last one is repeated, so extra retries
+// see the final outcome.
+func (c *cassette) play(req *http.Request) (*http.Response, error) {
+	key := requestKey(req)
+	c.mu.Lock()
+	var matches []*interaction
+	for _, it := range c.file.Interactions {
+		if it.Key == key {
+			matches = append(matches, it)
+		}
+	}
+	n := c.served[key]
+	c.served[key]++
+	c.mu.Unlock()
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotRecorded, key)
+	}
+	it := matches[min(n, len(matches)-1)]
+	if it.Error != "" {
+		return nil, errors.New(it.Error)
+	}
+	body, err := os.ReadFile(filepath.Join(c.dir, it.BodyFile))
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", it.Status, http.StatusText(it.Status)),
+		StatusCode:    it.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        it.Headers.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (c *cassette) save() error {
+	return writeFileAtomic(filepath.Join(c.dir, cassetteIndex), func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(c.file)
+	})
+}
+
+// transportErrorKind classifies an error from http.Client.Do. A request
+// missing from a replayed cassette will not appear by retrying.
+func transportErrorKind(err error) error {
+	if errors.Is(err, ErrNotRecorded) {
+		return ErrRejected
+	}
+	return ErrNetwork
+}
+
+// cassetteTransport returns the transport for cfg: a cassette when
+// recording or replaying, otherwise nil for http.DefaultTransport.
+func cassetteTransport(cfg *Config) (http.RoundTripper, error) {
+	switch {
+	case cfg.Replay != "":
+		return openCassette(cfg.Replay, true)
+	case cfg.Record != "":
+		return openCassette(cfg.Record, false)
+	}
+	return nil, nil
+}
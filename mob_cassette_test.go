@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCassette_RecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewServer(newMockServer("10001", "mock-secret", 7, mockFaults{}, 1))
+	cfg := &Config{
+		BaseURL:     server.URL + mockPath,
+		ClientKey:   "10001",
+		PerPage:     10,
+		Concurrency: 3,
+		Timeout:     2 * time.Second,
+		Record:      dir,
+		secret:      "mock-secret",
+	}
+	var err error
+	if cfg.transport, err = cassetteTransport(cfg); err != nil {
+		t.Fatal(err)
+	}
+	window, err := resolveDateRange("2025-07-04", "2025-07-11", defaultDays, false, "UTC", time.Date(2025, 7, 20, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := fetchAllPages(context.Background(), newAPIClient(cfg), window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	index, err := os.ReadFile(filepath.Join(dir, cassetteIndex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(index), "mock-secret") || strings.Contains(string(index), "token=") && !strings.Contains(string(index), "token=REDACTED") {
+		t.Errorf("Cassette leaks credentials:\n%s", index)
+	}
+	if body, err := os.ReadFile(filepath.Join(dir, "0001.body")); err != nil || !strings.Contains(string(body), `"total"`) {
+		t.Errorf("Expected the raw page 1 body, got %q (%v)", body, err)
+	}
+
+	// the server is gone and there is no secret: everything comes from disk
+	cfg.Record, cfg.Replay, cfg.secret = "", dir, ""
+	if cfg.transport, err = cassetteTransport(cfg); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := fetchAllPages(context.Background(), newAPIClient(cfg), window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != len(recorded) || len(replayed) != 56 {
+		t.Fatalf("Expected 56 rows replayed, got %d of %d", len(replayed), len(recorded))
+	}
+	for i := range recorded {
+		if a, b := recorded[i].Value("revenue_d7", defaultFormatOptions), replayed[i].Value("revenue_d7", defaultFormatOptions); a != b {
+			t.Fatalf("Row %d differs on replay: %s vs %s", i, a, b)
+		}
+	}
+
+	other := window
+	other.End = other.End.AddDate(0, 0, 1)
+	if _, err := fetchAllPages(context.Background(), newAPIClient(cfg), other); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("Expected ErrNotRecorded for a different window, got %v", err)
+	}
+}
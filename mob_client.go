@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 4
+	retryBaseDelay    = 500 * time.Millisecond
+	retryMaxDelay     = 30 * time.Second
+	maxRetryAfter     = 2 * time.Minute
+)
+
+// Error kinds returned by apiClient. Match them with errors.Is and use
+// errors.As with *APIError to get at the status code and API message.
+var (
+	ErrAuth        = errors.New("authentication failed")
+	ErrRateLimited = errors.New("rate limited")
+	ErrServer      = errors.New("server error")
+	ErrDecode      = errors.New("undecodable response")
+	ErrRejected    = errors.New("request rejected")
+	ErrNetwork     = errors.New("network error")
+)
+
+// APIError describes a failed request after retries were exhausted.
+type APIError struct {
+	Kind       error
+	Page       int
+	StatusCode int
+	Message    string // error message reported by the API, if any
+	Attempts   int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("page %d: %v", e.Page, e.Kind)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (HTTP %d)", e.StatusCode)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Attempts > 1 {
+		msg += fmt.Sprintf(" after %d attempts", e.Attempts)
+	}
+	return msg
+}
+
+func (e *APIError) Is(target error) bool { return target == e.Kind }
+
+func (e *APIError) Unwrap() error { return e.Err }
+
+func (e *APIError) retryable() bool {
+	return e.Kind == ErrServer || e.Kind == ErrRateLimited || e.Kind == ErrNetwork
+}
+
+// exitCode maps an error to the process exit status so a cron wrapper can
+// tell "alert a human" (auth, decode) from "try again later" (rate limit,
+// server, network) without parsing log output.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrAuth):
+		return 3
+	case errors.Is(err, ErrRateLimited):
+		return 4
+	case errors.Is(err, ErrServer), errors.Is(err, ErrNetwork):
+		return 5
+	case errors.Is(err, ErrDecode):
+		return 6
+	case errors.Is(err, ErrRejected):
+		return 7
+	case errors.Is(err, ErrSchemaDrift):
+		return 8
+	case errors.Is(err, ErrQuality):
+		return 9
+	default:
+		return 1
+	}
+}
+
+// apiClient fetches report pages with a per-request timeout and retries
+// transient failures with exponential backoff and jitter.
+type apiClient struct {
+	cfg        *Config
+	httpClient *http.Client
+	auth       authStrategy
+	limiter    *rateLimiter // optional, shared across clients
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+func newAPIClient(cfg *Config) *apiClient {
+	return &apiClient{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: cfg.Timeout, Transport: cfg.transport},
+		auth:       signedQueryAuth{NewSigner(cfg.ClientKey, cfg.Secret())},
+		maxRetries: cfg.MaxRetries,
+		baseDelay:  retryBaseDelay,
+		maxDelay:   retryMaxDelay,
+	}
+}
+
+// fetchPage requests one page, retrying transient failures. The URL is
+// re-signed on every attempt so retries never reuse a stale time/token.
+func (c *apiClient) fetchPage(ctx context.Context, window DateRange, page int) (*ApiResponse, error) {
+	var resp *ApiResponse
+	err := c.retry(ctx, page, func() *APIError {
+		var err *APIError
+		resp, err = c.do(ctx, window, page)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// retry calls attempt until it succeeds, fails permanently or the retries
+// run out, backing off in between.
+func (c *apiClient) retry(ctx context.Context, page int, attempt func() *APIError) error {
+	var lastErr *APIError
+	for n := 0; n <= c.maxRetries; n++ {
+		if n > 0 {
+			delay := c.backoff(n, lastErr.RetryAfter)
+			fmt.Printf("Retrying page %d in %v (%v)\n", page, delay.Round(time.Millisecond), lastErr.Kind)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		lastErr.Attempts = n + 1
+		if !err.retryable() || ctx.Err() != nil {
+			break
+		}
+	}
+	return lastErr
+}
+
+// newRequest builds a GET for url and applies the client's auth strategy.
+// Signing happens here, once per attempt, so retries never reuse a token.
+func (c *apiClient) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.auth.authorize(req)
+	fmt.Println("Request URL:", redactURL(req.URL.String()))
+	return req, nil
+}
+
+func (c *apiClient) do(ctx context.Context, window DateRange, page int) (*ApiResponse, *APIError) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, &APIError{Kind: ErrNetwork, Page: page, Err: err}
+	}
+	apiURL, err := prepareApiUrl(c.cfg, window, page, c.cfg.PerPage)
+	if err != nil {
+		return nil, &APIError{Kind: ErrRejected, Page: page, Err: err}
+	}
+	req, err := c.newRequest(ctx, apiURL)
+	if err != nil {
+		return nil, &APIError{Kind: ErrRejected, Page: page, Err: err}
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, &APIError{Kind: transportErrorKind(err), Page: page, Err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &APIError{Kind: ErrNetwork, Page: page, StatusCode: resp.StatusCode, Err: err}
+	}
+
+	var apiResponse ApiResponse
+	decodeErr := json.Unmarshal(body, &apiResponse)
+
+	if kind := kindForStatus(resp.StatusCode); kind != nil {
+		return nil, &APIError{
+			Kind:       kind,
+			Page:       page,
+			StatusCode: resp.StatusCode,
+			Message:    firstNonEmpty(apiResponse.message(), snippet(body)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	if decodeErr != nil {
+		return nil, &APIError{Kind: ErrDecode, Page: page, StatusCode: resp.StatusCode, Message: snippet(body), Err: decodeErr}
+	}
+	if !apiResponse.Status {
+		kind := ErrRejected
+		if looksLikeAuthFailure(apiResponse.message()) {
+			kind = ErrAuth
+		}
+		return nil, &APIError{Kind: kind, Page: page, StatusCode: resp.StatusCode, Message: apiResponse.message()}
+	}
+	return &apiResponse, nil
+}
+
+// backoff returns the delay before the given retry attempt. A Retry-After
+// from the server wins; otherwise the delay doubles per attempt up to
+// maxDelay and is jittered to the upper half of that range.
+func (c *apiClient) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	delay := c.baseDelay << (attempt - 1)
+	if delay <= 0 || delay > c.maxDelay {
+		delay = c.maxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func kindForStatus(code int) error {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ErrAuth
+	case code == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case code >= 500:
+		return ErrServer
+	case code >= 400:
+		return ErrRejected
+	default:
+		return nil
+	}
+}
+
+// parseRetryAfter accepts both forms allowed by RFC 9110: delay seconds or
+// an HTTP date. Unparseable values yield zero so normal backoff applies.
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	}
+	if d < 0 {
+		return 0
+	}
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
+
+func looksLikeAuthFailure(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, hint := range []string{"token", "auth", "sign", "client_key", "permission"} {
+		if strings.Contains(msg, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// snippet trims a response body to something safe to put in an error.
+func snippet(body []byte) string {
+	const limit = 200
+	s := strings.TrimSpace(string(body))
+	if len(s) > limit {
+		s = s[:limit] + "..."
+	}
+	return s
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	cases := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"", 0, 0},
+		{"7", 7 * time.Second, 7 * time.Second},
+		{" 30 ", 30 * time.Second, 30 * time.Second},
+		{"-5", 0, 0},
+		{"86400", maxRetryAfter, maxRetryAfter},
+		{"soon", 0, 0},
+		// HTTP dates are relative to now, so allow for the test's own runtime
+		{time.Now().Add(20 * time.Second).UTC().Format(http.TimeFormat), 18 * time.Second, 20 * time.Second},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), maxRetryAfter, maxRetryAfter},
+	}
+	for _, c := range cases {
+		if got := parseRetryAfter(c.value); got < c.min || got > c.max {
+			t.Errorf("%q: expected %v..%v, got %v", c.value, c.min, c.max, got)
+		}
+	}
+}
+
+func TestBackoff_Jitter(t *testing.T) {
+	c := &apiClient{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+	cases := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{4, 400 * time.Millisecond, 800 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second},
+		// the shift overflows: still capped at maxDelay
+		{80, 500 * time.Millisecond, time.Second},
+	}
+	for _, step := range cases {
+		for i := 0; i < 200; i++ {
+			if got := c.backoff(step.attempt, 0); got < step.min || got > step.max {
+				t.Fatalf("attempt %d: expected %v..%v, got %v", step.attempt, step.min, step.max, got)
+			}
+		}
+	}
+	if got := c.backoff(3, 7*time.Second); got != 7*time.Second {
+		t.Errorf("Expected Retry-After to win over backoff, got %v", got)
+	}
+}
+
+// scriptedClient returns a client for a server that answers with the given
+// status codes in turn, then with an empty successful page.
+func scriptedClient(t *testing.T, statuses ...int) (*apiClient, *int) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= len(statuses) {
+			w.WriteHeader(statuses[calls-1])
+			fmt.Fprintf(w, `{"status":false,"msg":"status %d"}`, statuses[calls-1])
+			return
+		}
+		fmt.Fprint(w, `{"status":true,"data":{"total":0,"data":[]}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client := newAPIClient(&Config{BaseURL: server.URL, ClientKey: "1", PerPage: 10, Timeout: time.Second, MaxRetries: 2, secret: "s"})
+	client.baseDelay = time.Millisecond
+	client.maxDelay = 2 * time.Millisecond
+	return client, &calls
+}
+
+func TestFetchPage_Retry(t *testing.T) {
+	cases := []struct {
+		name     string
+		statuses []int
+		want     error
+		calls    int
+	}{
+		{"ok", nil, nil, 1},
+		{"server error then ok", []int{502}, nil, 2},
+		{"rate limited then ok", []int{429, 503}, nil, 3},
+		{"retries run out", []int{500, 500, 500}, ErrServer, 3},
+		{"auth is not retried", []int{401}, ErrAuth, 1},
+		{"bad request is not retried", []int{400}, ErrRejected, 1},
+	}
+	window := DateRange{Start: time.Date(2025, 7, 4, 0, 0, 0, 0, time.UTC), End: time.Date(2025, 7, 4, 0, 0, 0, 0, time.UTC), Location: time.UTC}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, calls := scriptedClient(t, c.statuses...)
+			_, err := client.fetchPage(context.Background(), window, 1)
+			if !errors.Is(err, c.want) || (c.want == nil && err != nil) {
+				t.Errorf("Expected %v, got %v", c.want, err)
+			}
+			if *calls != c.calls {
+				t.Errorf("Expected %d requests, got %d", c.calls, *calls)
+			}
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.Attempts != c.calls {
+				t.Errorf("Expected %d attempts recorded, got %d", c.calls, apiErr.Attempts)
+			}
+		})
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	cases := []struct {
+		status int
+		want   error
+		exit   int
+	}{
+		{200, nil, 0},
+		{401, ErrAuth, 3},
+		{403, ErrAuth, 3},
+		{429, ErrRateLimited, 4},
+		{500, ErrServer, 5},
+		{504, ErrServer, 5},
+		{404, ErrRejected, 7},
+	}
+	for _, c := range cases {
+		kind := kindForStatus(c.status)
+		if kind != c.want {
+			t.Errorf("HTTP %d: expected %v, got %v", c.status, c.want, kind)
+		}
+		if kind == nil {
+			continue
+		}
+		err := fmt.Errorf("fetch: %w", &APIError{Kind: kind, Page: 3, StatusCode: c.status})
+		if !errors.Is(err, c.want) || exitCode(err) != c.exit {
+			t.Errorf("HTTP %d: expected a wrapped %v with exit code %d, got %v (%d)", c.status, c.want, c.exit, err, exitCode(err))
+		}
+	}
+	if exitCode(&APIError{Kind: ErrDecode}) != 6 || exitCode(errors.New("other")) != 1 {
+		t.Error("Unexpected exit codes for decode or unknown errors")
+	}
+}
+
+func TestPrepareApiUrl(t *testing.T) {
+	window, err := resolveDateRange("2025-07-04", "2025-07-05", defaultDays, false, "UTC", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		base string
+		want string
+	}{
+		{"https://api.example/v1", "https://api.example/v1?end_date=2025-07-05&page=2&per_page=50&start_date=2025-07-04"},
+		// base_url's own query is kept; the page parameters replace stale ones
+		{"https://api.example/v1?region=eu&page=9", "https://api.example/v1?end_date=2025-07-05&page=2&per_page=50&region=eu&start_date=2025-07-04"},
+	}
+	for _, c := range cases {
+		got, err := prepareApiUrl(&Config{BaseURL: c.base}, window, 2, 50)
+		if err != nil || got != c.want {
+			t.Errorf("%s: expected %s, got %s (%v)", c.base, c.want, got, err)
+		}
+	}
+	if _, err := prepareApiUrl(&Config{BaseURL: "https://api.example/%zz"}, window, 1, 50); err == nil {
+		t.Error("Expected an invalid base_url to be an error")
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const defaultDerivedDecimals = 4
+
+// Column is one output column: a header name and how to get its value
+// from a row. Day is the cohort day the value depends on (rr_d7 -> 7), or
+// -1 when it does not depend on one; see mob_maturity.go.
+type Column struct {
+	Name   string
+	Day    int
+	fields []string // row fields the column reads
+	value  func(row *IAARow, opts FormatOptions) string
+}
+
+func (c Column) Value(row *IAARow, opts FormatOptions) string {
+	return c.value(row, opts)
+}
+
+// fieldColumn copies an API field, optionally under a different name.
+// Formatting follows the source field, so a renamed rr_d1 still honours
+// -rate-decimals.
+func fieldColumn(name, source string) Column {
+	return Column{Name: name, Day: cohortDay(source), fields: []string{source}, value: func(row *IAARow, opts FormatOptions) string {
+		return row.Value(source, opts)
+	}}
+}
+
+func fieldColumns(names []string) []Column {
+	columns := make([]Column, len(names))
+	for i, name := range names {
+		columns[i] = fieldColumn(name, name)
+	}
+	return columns
+}
+
+func columnNames(columns []Column) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// columnLayout is the parsed -columns flag.
+type columnLayout struct {
+	mode    string // "fixed", "sorted" or "file"
+	path    string
+	columns []Column // for mode "file"
+}
+
+func parseColumnLayout(v string) (*columnLayout, error) {
+	switch {
+	case v == "" || v == "fixed":
+		return &columnLayout{mode: "fixed"}, nil
+	case v == "sorted":
+		return &columnLayout{mode: "sorted"}, nil
+	case strings.HasPrefix(v, "file:"):
+		path := strings.TrimPrefix(v, "file:")
+		columns, err := loadColumnFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return &columnLayout{mode: "file", path: path, columns: columns}, nil
+	}
+	return nil, fmt.Errorf("invalid -columns %q: want fixed, sorted or file:<path>", v)
+}
+
+func (l *columnLayout) String() string {
+	if l.mode == "file" {
+		return "file:" + l.path
+	}
+	return l.mode
+}
+
+// resolve returns the columns to write. expected is the fixed column list,
+// possibly extended by the schema check; sorted mode uses the union of
+// every field seen in rows instead, alphabetically.
+func (l *columnLayout) resolve(expected []string, rows []*IAARow) []Column {
+	switch l.mode {
+	case "sorted":
+		seen := make(map[string]bool)
+		for _, row := range rows {
+			for name := range row.kinds {
+				seen[name] = true
+			}
+		}
+		return fieldColumns(sortedKeys(seen))
+	case "file":
+		return l.columns
+	}
+	return fieldColumns(expected)
+}
+
+// loadColumnFile reads a column template. Each non-blank line that is not
+// a # comment declares one output column, in output order:
+//
+//	date                       copy a field
+//	installs = install         copy a field under a new name
+//	roas_d7_pct = d7_roas*100  derive a value with + - * / and parentheses
+//
+// Fields not listed are dropped. A derived value can be given its own
+// number of decimals with a trailing "| decimals=N".
+//
+// Columns may only read fields of the fixed schema, other day-N metrics,
+// and fields declared on a "fields: a, b" line, so a misspelt name is an
+// error rather than a column that is always blank.
+func loadColumnFile(path string) ([]Column, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var columns []Column
+	var lines []int
+	names := make(map[string]bool)
+	known := make(map[string]bool)
+	for _, name := range fixedFieldOrder {
+		known[name] = true
+	}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if list, ok := strings.CutPrefix(line, "fields:"); ok {
+			for _, name := range splitList(list) {
+				if !isIdent(name) {
+					return nil, fmt.Errorf("%s:%d: invalid field name %q", path, lineNo, name)
+				}
+				known[name] = true
+			}
+			continue
+		}
+		column, err := parseColumnLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+		if names[column.Name] {
+			return nil, fmt.Errorf("%s:%d: duplicate column %q", path, lineNo, column.Name)
+		}
+		names[column.Name] = true
+		columns = append(columns, column)
+		lines = append(lines, lineNo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, column := range columns {
+		for _, field := range column.fields {
+			if !known[field] && cohortDay(field) < 0 {
+				return nil, fmt.Errorf("%s:%d: column %s: unknown field %q (declare fields outside the fixed schema with a fields: line)", path, lines[i], column.Name, field)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%s: no columns defined", path)
+	}
+	return columns, nil
+}
+
+func parseColumnLine(line string) (Column, error) {
+	decimals := defaultDerivedDecimals
+	if def, opt, ok := strings.Cut(line, "|"); ok {
+		line = strings.TrimSpace(def)
+		if _, err := fmt.Sscanf(strings.TrimSpace(opt), "decimals=%d", &decimals); err != nil || decimals < 0 {
+			return Column{}, fmt.Errorf("invalid option %q: want decimals=N", strings.TrimSpace(opt))
+		}
+	}
+
+	name, source, hasExpr := strings.Cut(line, "=")
+	name = strings.TrimSpace(name)
+	if !isIdent(name) {
+		return Column{}, fmt.Errorf("invalid column name %q", name)
+	}
+	if !hasExpr {
+		return fieldColumn(name, name), nil
+	}
+	source = strings.TrimSpace(source)
+	if isIdent(source) {
+		return fieldColumn(name, source), nil
+	}
+
+	expr, err := parseExpr(source)
+	if err != nil {
+		return Column{}, fmt.Errorf("column %s: %v", name, err)
+	}
+	fields := exprFields(expr)
+	day := -1
+	for _, field := range fields {
+		day = max(day, cohortDay(field))
+	}
+	return Column{Name: name, Day: day, fields: fields, value: func(row *IAARow, _ FormatOptions) string {
+		v, ok := expr.eval(row)
+		if !ok {
+			return ""
+		}
+		return v.FloatString(decimals)
+	}}, nil
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
+
+// expr is a parsed arithmetic expression over row fields. It is evaluated
+// with exact rationals so derived money columns do not pick up float noise.
+type expr interface {
+	// eval returns false when a field is empty or a division by zero
+	// happens; the column is then left blank.
+	eval(row *IAARow) (*big.Rat, bool)
+}
+
+type numberExpr struct{ v *big.Rat }
+type fieldExpr struct{ name string }
+type negExpr struct{ x expr }
+type binaryExpr struct {
+	op   byte
+	l, r expr
+}
+
+func (e numberExpr) eval(*IAARow) (*big.Rat, bool) { return e.v, true }
+
+func (e fieldExpr) eval(row *IAARow) (*big.Rat, bool) {
+	return rowRat(row, e.name)
+}
+
+func (e negExpr) eval(row *IAARow) (*big.Rat, bool) {
+	v, ok := e.x.eval(row)
+	if !ok {
+		return nil, false
+	}
+	return new(big.Rat).Neg(v), true
+}
+
+func (e binaryExpr) eval(row *IAARow) (*big.Rat, bool) {
+	l, ok := e.l.eval(row)
+	if !ok {
+		return nil, false
+	}
+	r, ok := e.r.eval(row)
+	if !ok {
+		return nil, false
+	}
+	switch e.op {
+	case '+':
+		return new(big.Rat).Add(l, r), true
+	case '-':
+		return new(big.Rat).Sub(l, r), true
+	case '*':
+		return new(big.Rat).Mul(l, r), true
+	default:
+		if r.Sign() == 0 {
+			return nil, false
+		}
+		return new(big.Rat).Quo(l, r), true
+	}
+}
+
+// exprFields lists the row fields an expression reads.
+func exprFields(e expr) []string {
+	switch e := e.(type) {
+	case fieldExpr:
+		return []string{e.name}
+	case negExpr:
+		return exprFields(e.x)
+	case binaryExpr:
+		return append(exprFields(e.l), exprFields(e.r)...)
+	}
+	return nil
+}
+
+// rowRat returns a numeric field as an exact rational.
+func rowRat(row *IAARow, name string) (*big.Rat, bool) {
+	var raw string
+	switch v := row.field(name).(type) {
+	case *Number:
+		if !v.Valid {
+			return nil, false
+		}
+		raw = v.raw
+	case *Text:
+		raw = string(*v)
+	default:
+		extra, ok := row.Extras[name]
+		if !ok {
+			return nil, false
+		}
+		if json.Unmarshal(extra, &raw) != nil {
+			raw = string(extra)
+		}
+	}
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(raw))
+	return r, ok
+}
+
+// exprParser is a small recursive descent parser:
+//
+//	expr   = term { ("+"|"-") term }
+//	term   = unary { ("*"|"/") unary }
+//	unary  = "-" unary | factor
+//	factor = number | field | "(" expr ")"
+type exprParser struct {
+	s   string
+	pos int
+}
+
+func parseExpr(s string) (expr, error) {
+	p := &exprParser{s: s}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.s[p.pos:], p.pos+1)
+	}
+	return e, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) {
+		r, size := utf8.DecodeRuneInString(p.s[p.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		p.pos += size
+	}
+}
+
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) expr() (expr, error) {
+	l, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		r, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryExpr{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) term() (expr, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryExpr{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) unary() (expr, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return negExpr{x}, nil
+	}
+	return p.factor()
+}
+
+func (p *exprParser) factor() (expr, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos+1)
+		}
+		p.pos++
+		return e, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.s) && (p.s[p.pos] == '.' || (p.s[p.pos] >= '0' && p.s[p.pos] <= '9')) {
+			p.pos++
+		}
+		v, ok := new(big.Rat).SetString(p.s[start:p.pos])
+		if !ok {
+			return nil, fmt.Errorf("invalid number %q", p.s[start:p.pos])
+		}
+		return numberExpr{v}, nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.s) && (p.s[p.pos] == '_' || unicode.IsLetter(rune(p.s[p.pos])) || unicode.IsDigit(rune(p.s[p.pos]))) {
+			p.pos++
+		}
+		return fieldExpr{p.s[start:p.pos]}, nil
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", c, p.pos+1)
+}
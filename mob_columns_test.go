@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func decodeRow(t *testing.T, data string) *IAARow {
+	t.Helper()
+	var row IAARow
+	if err := json.Unmarshal([]byte(data), &row); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	return &row
+}
+
+func TestColumnFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "columns.txt")
+	spec := `# finance layout
+fields: campaign_bonus
+date
+installs = install
+roas_d7_pct = d7_roas*100 | decimals=2
+arpu_d7 = revenue_d7 / install
+net = -(revenue_d7 - 10) + campaign_bonus
+`
+	if err := os.WriteFile(path, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	layout, err := parseColumnLayout("file:" + path)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	row := decodeRow(t, `{"date":"2025-07-04","install":"200","d7_roas":0.1234,"revenue_d7":"50.5","campaign_bonus":"1.25","offer_id":1}`)
+	columns := layout.resolve(fixedFieldOrder, []*IAARow{row})
+
+	if got := strings.Join(columnNames(columns), ","); got != "date,installs,roas_d7_pct,arpu_d7,net" {
+		t.Errorf("Expected renamed and reordered header, got %s", got)
+	}
+	want := []string{"2025-07-04", "200", "12.34", "0.2525", "-39.2500"}
+	for i, column := range columns {
+		if got := column.Value(row, defaultFormatOptions); got != want[i] {
+			t.Errorf("%s: expected %q, got %q", column.Name, want[i], got)
+		}
+	}
+}
+
+func TestColumnFile_BlankWhenUndefined(t *testing.T) {
+	column, err := parseColumnLine("arpu = revenue_d7 / install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{`{"revenue_d7":1,"install":0}`, `{"revenue_d7":null,"install":5}`, `{"install":5}`} {
+		if got := column.Value(decodeRow(t, data), defaultFormatOptions); got != "" {
+			t.Errorf("%s: expected blank, got %q", data, got)
+		}
+	}
+}
+
+func TestColumnFile_UnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "columns.txt")
+	for spec, want := range map[string]string{
+		"date\narpu = revenu_d7 / install\n":                   `:2: column arpu: unknown field "revenu_d7"`,
+		"x = campaign_bonus * 2\n":                             `:1: column x: unknown field "campaign_bonus"`,
+		"x = campaign_bonus * 2\nfields: campaign_bonus, 2x\n": `:2: invalid field name "2x"`,
+		"x = campaign_bonus * 2\nfields: campaign_bonus\n":     "",
+		"growth = revenue_d60 / revenue_d1\n":                  "",
+		"tabs =\td7_roas\t*\u00a0100\n":                        "",
+		"date\ninstal\n":                                       `:2: column instal: unknown field "instal"`,
+		"x = instal\n":                                         `:1: column x: unknown field "instal"`,
+		"fields: campaign_bonus\nbonus = campaign_bonus\n":     "",
+	} {
+		os.WriteFile(path, []byte(spec), 0644)
+		_, err := loadColumnFile(path)
+		if (want == "") != (err == nil) || err != nil && !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error %q, got %v", spec, want, err)
+		}
+	}
+}
+
+func TestColumnFile_Rejects(t *testing.T) {
+	for _, line := range []string{
+		"1bad",
+		"x = d7_roas *",
+		"x = (d7_roas",
+		"x = d7_roas # 2",
+		"x = d7_roas | decimals=-1",
+		"x = d7_roas | precision=2",
+	} {
+		if _, err := parseColumnLine(line); err == nil {
+			t.Errorf("Expected %q to be rejected", line)
+		}
+	}
+	if _, err := parseColumnLayout("alphabetical"); err == nil {
+		t.Error("Expected unknown -columns mode to be rejected")
+	}
+}
+
+func TestColumnLayout_Sorted(t *testing.T) {
+	layout, _ := parseColumnLayout("sorted")
+	rows := []*IAARow{
+		decodeRow(t, `{"offer_id":1,"date":"2025-07-04"}`),
+		decodeRow(t, `{"install":3,"zz_extra":"x"}`),
+	}
+	if got := strings.Join(columnNames(layout.resolve(fixedFieldOrder, rows)), ","); got != "date,install,offer_id,zz_extra" {
+		t.Errorf("Expected sorted union of fields, got %s", got)
+	}
+}
+
+func TestColumnLayout_ExampleMatchesFixed(t *testing.T) {
+	layout, err := parseColumnLayout("file:columns.example.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(columnNames(layout.columns), ","); got != strings.Join(fixedFieldOrder, ",") {
+		t.Errorf("columns.example.txt drifted from fixedFieldOrder: %s", got)
+	}
+}
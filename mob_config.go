@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBaseURL    = "https://open.3s.mobvista.com/channel/iaa/v1"
+	defaultConfigPath = "mobvista.json"
+)
+
+// Profile is one named account in the config file. The secret is never
+// stored inline: SecretRef points at where to read it from, either
+// "env:VAR_NAME" or "file:/path/to/secret".
+type Profile struct {
+	BaseURL   string `json:"base_url"`
+	ClientKey string `json:"client_key"`
+	SecretRef string `json:"secret_ref"`
+	PerPage   int    `json:"per_page"`
+	OutputDir string `json:"output_dir"`
+	// QPS limits this account's requests per second when it is exported
+	// with -accounts; 0 uses -account-qps.
+	QPS float64 `json:"qps,omitempty"`
+	// Source picks the adapter: mobvista (default) or bearer_json, which
+	// is configured by BearerJSON and takes its token from SecretRef.
+	Source     string          `json:"source,omitempty"`
+	BearerJSON *bearerJSONSpec `json:"bearer_json,omitempty"`
+}
+
+type configFile struct {
+	DefaultProfile string             `json:"default_profile"`
+	Profiles       map[string]Profile `json:"profiles"`
+}
+
+// Config is the resolved settings for one run. Values are layered in this
+// order, later ones winning: built-in defaults, the selected profile from the
+// config file, MOB_* environment variables, then command line flags.
+type Config struct {
+	Profile     string
+	BaseURL     string
+	ClientKey   string
+	PerPage     int
+	Concurrency int
+	OutputDir   string
+	Timeout     time.Duration
+	MaxRetries  int
+	QPS         float64
+	Source      string
+	BearerJSON  *bearerJSONSpec
+	Record      string // cassette directory API traffic is saved to
+	Replay      string // cassette directory API traffic is served from
+
+	secret    string
+	transport http.RoundTripper // nil for the default
+}
+
+// Secret returns the client secret key. It is kept unexported on the struct so
+// it never shows up when a Config is printed.
+func (c *Config) Secret() string {
+	return c.secret
+}
+
+func (c *Config) String() string {
+	secret := "<unset>"
+	if c.secret != "" {
+		secret = "<redacted>"
+	}
+	s := fmt.Sprintf("profile=%s base_url=%s client_key=%s secret=%s per_page=%d concurrency=%d output_dir=%s timeout=%v retries=%d",
+		c.Profile, c.BaseURL, c.ClientKey, secret, c.PerPage, c.Concurrency, c.OutputDir, c.Timeout, c.MaxRetries)
+	if c.Source != sourceMobvista {
+		s += " source=" + c.Source
+	}
+	if c.QPS > 0 {
+		s += fmt.Sprintf(" qps=%v", c.QPS)
+	}
+	return s
+}
+
+// configFlags holds the command line flags shared by every command that
+// talks to the API.
+type configFlags struct {
+	fs          *flag.FlagSet
+	configPath  string
+	profile     string
+	baseURL     string
+	clientKey   string
+	secretRef   string
+	perPage     int
+	concurrency int
+	outputDir   string
+	timeout     time.Duration
+	retries     int
+	record      string
+	replay      string
+}
+
+func registerConfigFlags(fs *flag.FlagSet) *configFlags {
+	f := &configFlags{fs: fs}
+	fs.StringVar(&f.configPath, "config", "", "path to the config file (default $MOB_CONFIG or "+defaultConfigPath+")")
+	fs.StringVar(&f.profile, "profile", "", "profile to use from the config file (default $MOB_PROFILE or the file's default_profile)")
+	fs.StringVar(&f.baseURL, "base-url", "", "API base URL")
+	fs.StringVar(&f.clientKey, "client-key", "", "API client key")
+	fs.StringVar(&f.secretRef, "secret-ref", "", "where to read the client secret from: env:VAR or file:PATH")
+	fs.IntVar(&f.perPage, "per-page", 0, "rows requested per page")
+	fs.IntVar(&f.concurrency, "concurrency", 0, "maximum page requests in flight")
+	fs.StringVar(&f.outputDir, "output-dir", "", "directory exported files are written to")
+	fs.DurationVar(&f.timeout, "timeout", defaultTimeout, "timeout for a single API request")
+	fs.IntVar(&f.retries, "retries", defaultMaxRetries, "retries for rate limited, 5xx and network failures")
+	fs.StringVar(&f.record, "record", "", "save every API request (credentials redacted) and response to this cassette directory")
+	fs.StringVar(&f.replay, "replay", "", "answer API requests from this cassette directory instead of the network")
+	return f
+}
+
+// resolve builds the Config once the flag set has been parsed.
+func (f *configFlags) resolve() (*Config, error) {
+	file, path, err := f.readFile()
+	if err != nil {
+		return nil, err
+	}
+	profile := firstNonEmpty(f.profile, os.Getenv("MOB_PROFILE"))
+	if file != nil && profile == "" {
+		profile = file.DefaultProfile
+	}
+	if file == nil && profile != "" {
+		return nil, fmt.Errorf("profile %q requested but no config file found at %s", profile, path)
+	}
+	return f.build(file, path, profile, true)
+}
+
+// resolveAccounts builds one Config per profile in list, a comma-separated
+// list of profile names or "all". Each account keeps the credentials of its
+// own profile: MOB_CLIENT_KEY, MOB_SECRET_REF, MOB_CLIENT_SECRET,
+// -client-key and -secret-ref are ignored, the other overrides still apply.
+func (f *configFlags) resolveAccounts(list string) ([]*Config, error) {
+	file, path, err := f.readFile()
+	if err != nil {
+		return nil, err
+	}
+	if file == nil || len(file.Profiles) == 0 {
+		return nil, fmt.Errorf("accounts are profiles, but no config file with profiles found at %s", path)
+	}
+	names := splitList(list)
+	if list == "all" {
+		names = sortedKeys(file.Profiles)
+	}
+	var cfgs []*Config
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		cfg, err := f.build(file, path, name, false)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %v", name, err)
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("no accounts in %q", list)
+	}
+	return cfgs, nil
+}
+
+// readFile reads the config file. A missing file is only an error when its
+// path was given explicitly; otherwise file is nil.
+func (f *configFlags) readFile() (file *configFile, path string, err error) {
+	path = firstNonEmpty(f.configPath, os.Getenv("MOB_CONFIG"))
+	explicitPath := path != ""
+	if path == "" {
+		path = defaultConfigPath
+	}
+	file, err = readConfigFile(path)
+	if err != nil && (explicitPath || !errors.Is(err, os.ErrNotExist)) {
+		return nil, path, err
+	}
+	return file, path, nil
+}
+
+// build layers profile, environment and flags into a Config. credentials
+// says whether the client key and secret may come from outside the
+// profile.
+func (f *configFlags) build(file *configFile, path, profile string, credentials bool) (*Config, error) {
+	set := make(map[string]bool)
+	f.fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	cfg := &Config{
+		Profile:     profile,
+		Source:      sourceMobvista,
+		BaseURL:     defaultBaseURL,
+		PerPage:     defaultPerPage,
+		Concurrency: defaultConcurrency,
+		OutputDir:   ".",
+		Timeout:     f.timeout,
+		MaxRetries:  f.retries,
+		Record:      f.record,
+		Replay:      f.replay,
+	}
+	secretRef := ""
+	var err error
+
+	// 1. Config file profile
+	if profile != "" {
+		p, ok := file.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile %q not found in %s", profile, path)
+		}
+		cfg.BaseURL = firstNonEmpty(p.BaseURL, cfg.BaseURL)
+		cfg.ClientKey = p.ClientKey
+		cfg.OutputDir = firstNonEmpty(p.OutputDir, cfg.OutputDir)
+		if p.PerPage > 0 {
+			cfg.PerPage = p.PerPage
+		}
+		cfg.QPS = p.QPS
+		cfg.Source = firstNonEmpty(p.Source, cfg.Source)
+		cfg.BearerJSON = p.BearerJSON
+		secretRef = p.SecretRef
+	}
+
+	// 2. Environment overrides
+	cfg.BaseURL = firstNonEmpty(os.Getenv("MOB_BASE_URL"), cfg.BaseURL)
+	cfg.OutputDir = firstNonEmpty(os.Getenv("MOB_OUTPUT_DIR"), cfg.OutputDir)
+	if credentials {
+		cfg.ClientKey = firstNonEmpty(os.Getenv("MOB_CLIENT_KEY"), cfg.ClientKey)
+		secretRef = firstNonEmpty(os.Getenv("MOB_SECRET_REF"), secretRef)
+	}
+	if v := os.Getenv("MOB_PER_PAGE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MOB_PER_PAGE %q: %v", v, err)
+		}
+		cfg.PerPage = n
+	}
+	if v := os.Getenv("MOB_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MOB_CONCURRENCY %q: %v", v, err)
+		}
+		cfg.Concurrency = n
+	}
+
+	// 3. Command line flags
+	if set["base-url"] {
+		cfg.BaseURL = f.baseURL
+	}
+	if set["client-key"] && credentials {
+		cfg.ClientKey = f.clientKey
+	}
+	if set["secret-ref"] && credentials {
+		secretRef = f.secretRef
+	}
+	if set["per-page"] {
+		cfg.PerPage = f.perPage
+	}
+	if set["concurrency"] {
+		cfg.Concurrency = f.concurrency
+	}
+	if set["output-dir"] {
+		cfg.OutputDir = f.outputDir
+	}
+
+	// MOB_CLIENT_SECRET is the one place a literal secret is accepted, so
+	// it can be injected by a secret manager without touching any file.
+	if credentials {
+		cfg.secret = os.Getenv("MOB_CLIENT_SECRET")
+	}
+	if secretRef != "" {
+		if cfg.secret, err = resolveSecret(secretRef); err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.Source {
+	case sourceMobvista:
+		if cfg.ClientKey == "" {
+			return nil, errors.New("no client key configured: set a profile, MOB_CLIENT_KEY or -client-key")
+		}
+	case sourceBearerJSON:
+		if cfg.BaseURL == defaultBaseURL {
+			return nil, errors.New("source bearer_json needs its own base_url")
+		}
+		if err := cfg.BearerJSON.validate(); err != nil {
+			return nil, fmt.Errorf("bearer_json: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown source %q (want %s or %s)", cfg.Source, sourceMobvista, sourceBearerJSON)
+	}
+	if cfg.secret == "" && cfg.Replay == "" {
+		return nil, errors.New("no client secret configured: set secret_ref, MOB_SECRET_REF, MOB_CLIENT_SECRET or -secret-ref")
+	}
+	if cfg.PerPage < 1 {
+		return nil, fmt.Errorf("per_page must be positive, got %d", cfg.PerPage)
+	}
+	if cfg.Concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be positive, got %d", cfg.Concurrency)
+	}
+	if cfg.MaxRetries < 0 {
+		return nil, fmt.Errorf("retries must not be negative, got %d", cfg.MaxRetries)
+	}
+	if cfg.QPS < 0 {
+		return nil, fmt.Errorf("qps must not be negative, got %v", cfg.QPS)
+	}
+	if cfg.Record != "" && cfg.Replay != "" {
+		return nil, errors.New("record and replay cannot be used together")
+	}
+	if cfg.transport, err = cassetteTransport(cfg); err != nil {
+		return nil, fmt.Errorf("cassette: %v", err)
+	}
+	return cfg, nil
+}
+
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %v", path, err)
+	}
+	return &file, nil
+}
+
+// resolveSecret reads a secret from an "env:" or "file:" reference. Errors
+// name the reference, never the value.
+func resolveSecret(ref string) (string, error) {
+	kind, target, ok := strings.Cut(ref, ":")
+	if !ok || target == "" {
+		return "", fmt.Errorf("invalid secret reference %q: want env:VAR or file:PATH", ref)
+	}
+	switch kind {
+	case "env":
+		v := os.Getenv(target)
+		if v == "" {
+			return "", fmt.Errorf("secret reference %s: environment variable is empty", ref)
+		}
+		return v, nil
+	case "file":
+		data, err := os.ReadFile(target)
+		if err != nil {
+			return "", fmt.Errorf("secret reference %s: %v", ref, err)
+		}
+		v := strings.TrimSpace(string(data))
+		if v == "" {
+			return "", fmt.Errorf("secret reference %s: file is empty", ref)
+		}
+		return v, nil
+	default:
+		return "", fmt.Errorf("invalid secret reference %q: unknown kind %q", ref, kind)
+	}
+}
+
+// redactURL masks the token query parameter so request URLs can be logged.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<unparseable url>"
+	}
+	q := u.Query()
+	if q.Has("token") {
+		q.Set("token", "REDACTED")
+	}
+	if q.Has("client_secret_key") {
+		q.Set("client_secret_key", "REDACTED")
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// splitList splits a comma-separated flag or query value, trimming spaces
+// and dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	return list
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configEnv lists every variable the config reads, so each case starts
+// from a clean environment.
+var configEnv = []string{"MOB_CONFIG", "MOB_PROFILE", "MOB_BASE_URL", "MOB_CLIENT_KEY", "MOB_SECRET_REF", "MOB_CLIENT_SECRET", "MOB_PER_PAGE", "MOB_CONCURRENCY", "MOB_OUTPUT_DIR"}
+
+func TestConfig_Precedence(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "b.secret")
+	if err := os.WriteFile(secretFile, []byte("b-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "mobvista.json")
+	config := `{"default_profile": "a", "profiles": {
+		"a": {"base_url": "https://a.example/v1", "client_key": "1", "secret_ref": "env:A_SECRET", "per_page": 100},
+		"b": {"base_url": "https://b.example/v1", "client_key": "2", "secret_ref": "file:` + filepath.ToSlash(secretFile) + `"}
+	}}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	overrides := map[string]string{
+		"MOB_BASE_URL":   "https://env.example/v1",
+		"MOB_CLIENT_KEY": "3",
+		"MOB_SECRET_REF": "env:ENV_SECRET",
+		"MOB_PER_PAGE":   "50",
+	}
+
+	cases := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		profile string
+		baseURL string
+		key     string
+		perPage int
+		secret  string
+	}{
+		{"default profile", nil, nil, "a", "https://a.example/v1", "1", 100, "a-secret"},
+		{"MOB_PROFILE", map[string]string{"MOB_PROFILE": "b"}, nil, "b", "https://b.example/v1", "2", defaultPerPage, "b-secret"},
+		{"-profile over MOB_PROFILE", map[string]string{"MOB_PROFILE": "b"}, []string{"-profile", "a"}, "a", "https://a.example/v1", "1", 100, "a-secret"},
+		{"environment over profile", overrides, nil, "a", "https://env.example/v1", "3", 50, "env-secret"},
+		{"flags over environment", overrides, []string{"-base-url", "https://flag.example/v1", "-client-key", "4", "-per-page", "20", "-secret-ref", "env:FLAG_SECRET"}, "a", "https://flag.example/v1", "4", 20, "flag-secret"},
+		// a literal secret only applies when no reference is configured
+		{"secret_ref over MOB_CLIENT_SECRET", map[string]string{"MOB_CLIENT_SECRET": "literal"}, nil, "a", "https://a.example/v1", "1", 100, "a-secret"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, name := range configEnv {
+				t.Setenv(name, "")
+			}
+			t.Setenv("A_SECRET", "a-secret")
+			t.Setenv("ENV_SECRET", "env-secret")
+			t.Setenv("FLAG_SECRET", "flag-secret")
+			for name, v := range c.env {
+				t.Setenv(name, v)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			f := registerConfigFlags(fs)
+			if err := fs.Parse(append([]string{"-config", path}, c.args...)); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := f.resolve()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Profile != c.profile || cfg.BaseURL != c.baseURL || cfg.ClientKey != c.key || cfg.PerPage != c.perPage || cfg.Secret() != c.secret {
+				t.Errorf("Expected profile=%s base_url=%s client_key=%s per_page=%d secret=%s, got %v with secret %s",
+					c.profile, c.baseURL, c.key, c.perPage, c.secret, cfg, cfg.Secret())
+			}
+		})
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	full := filepath.Join(dir, "secret")
+	empty := filepath.Join(dir, "empty")
+	os.WriteFile(full, []byte("  s3cret\n"), 0600)
+	os.WriteFile(empty, []byte("\n"), 0600)
+	t.Setenv("MOB_TEST_SECRET", "from-env")
+	t.Setenv("MOB_TEST_EMPTY", "")
+
+	cases := []struct {
+		ref  string
+		want string
+		err  string
+	}{
+		{"env:MOB_TEST_SECRET", "from-env", ""},
+		{"file:" + full, "s3cret", ""},
+		{"env:MOB_TEST_EMPTY", "", "environment variable is empty"},
+		{"env:MOB_TEST_UNSET_SECRET", "", "environment variable is empty"},
+		{"file:" + empty, "", "file is empty"},
+		{"file:" + filepath.Join(dir, "missing"), "", "secret reference file:"},
+		{"env:", "", "want env:VAR or file:PATH"},
+		{"MOB_TEST_SECRET", "", "want env:VAR or file:PATH"},
+		{"vault:mob/secret", "", `unknown kind "vault"`},
+	}
+	for _, c := range cases {
+		got, err := resolveSecret(c.ref)
+		if got != c.want || (c.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected %q (error %q), got %q, %v", c.ref, c.want, c.err, got, err)
+		}
+		if err != nil && strings.Contains(err.Error(), "from-env") {
+			t.Errorf("%s: error leaks the secret: %v", c.ref, err)
+		}
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	cases := map[string]string{
+		"https://api.example/v1?client_key=1&time=2&token=abc":  "https://api.example/v1?client_key=1&time=2&token=REDACTED",
+		"https://api.example/v1?client_secret_key=abc&page=1":   "https://api.example/v1?client_secret_key=REDACTED&page=1",
+		"https://api.example/v1?page=1":                         "https://api.example/v1?page=1",
+		"https://api.example/v1?token=a&token=b&token_type=raw": "https://api.example/v1?token=REDACTED&token_type=raw",
+		"://bad": "<unparseable url>",
+	}
+	for raw, want := range cases {
+		got := redactURL(raw)
+		if got != want {
+			t.Errorf("%s: expected %s, got %s", raw, want, got)
+		}
+		if strings.Contains(got, "abc") {
+			t.Errorf("%s: token left in %s", raw, got)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDashboardFile = "mobvista_dashboard.html"
+	defaultDashboardTop  = 8
+	defaultDashboardDay  = 7
+)
+
+// chartColors are assigned to series in order; the dashboard never draws
+// more series than colors.
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#17becf", "#bcbd22", "#7f7f7f"}
+
+// Chart geometry in SVG user units.
+const (
+	chartWidth  = 640
+	chartHeight = 260
+	chartLeft   = 56
+	chartRight  = 12
+	chartTop    = 12
+	chartBottom = 28
+	chartYTicks = 4
+	chartXTicks = 6
+)
+
+// chartSeries is one line. Values line up with the chart's categories;
+// nil is a gap.
+type chartSeries struct {
+	Name   string
+	Values []*float64
+}
+
+// lineChart is a chart before layout.
+type lineChart struct {
+	Title      string
+	Categories []string
+	Series     []chartSeries
+	Format     func(float64) string
+}
+
+// svgChart is a laid out chart, ready for the template.
+type svgChart struct {
+	Title         string
+	Width, Height int
+	Left, Right   float64
+	Top, Bottom   float64
+	Lines         []svgLine
+	YTicks        []svgTick
+	XTicks        []svgTick
+}
+
+type svgLine struct {
+	Name  string
+	Color string
+	Path  string
+	Dots  []svgDot
+}
+
+type svgDot struct {
+	X, Y float64
+	Tip  string
+}
+
+type svgTick struct {
+	Pos   float64
+	Label string
+}
+
+// niceCeil rounds v up to 1, 2, 2.5 or 5 times a power of ten so axis
+// ticks land on round numbers.
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	exp := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 2.5, 5, 10} {
+		if v <= m*exp {
+			return m * exp
+		}
+	}
+	return 10 * exp
+}
+
+// layout scales the chart into SVG coordinates.
+func (c *lineChart) layout() svgChart {
+	out := svgChart{
+		Title: c.Title, Width: chartWidth, Height: chartHeight,
+		Left: chartLeft, Right: chartWidth - chartRight,
+		Top: chartTop, Bottom: chartHeight - chartBottom,
+	}
+	maxY := 0.0
+	for _, s := range c.Series {
+		for _, v := range s.Values {
+			if v != nil {
+				maxY = math.Max(maxY, *v)
+			}
+		}
+	}
+	maxY = niceCeil(maxY)
+	x := func(i int) float64 {
+		if len(c.Categories) < 2 {
+			return (out.Left + out.Right) / 2
+		}
+		return math.Round((out.Left+float64(i)*(out.Right-out.Left)/float64(len(c.Categories)-1))*10) / 10
+	}
+	y := func(v float64) float64 {
+		return math.Round((out.Bottom-v/maxY*(out.Bottom-out.Top))*10) / 10
+	}
+
+	for i := 0; i <= chartYTicks; i++ {
+		v := maxY * float64(i) / chartYTicks
+		out.YTicks = append(out.YTicks, svgTick{Pos: y(v), Label: c.Format(v)})
+	}
+	step := max(1, (len(c.Categories)+chartXTicks-1)/chartXTicks)
+	for i := 0; i < len(c.Categories); i += step {
+		out.XTicks = append(out.XTicks, svgTick{Pos: x(i), Label: c.Categories[i]})
+	}
+
+	for n, s := range c.Series {
+		line := svgLine{Name: s.Name, Color: chartColors[n%len(chartColors)]}
+		var path strings.Builder
+		pen := "M"
+		for i, v := range s.Values {
+			if v == nil {
+				pen = "M"
+				continue
+			}
+			fmt.Fprintf(&path, "%s%v,%v ", pen, x(i), y(*v))
+			pen = "L"
+			line.Dots = append(line.Dots, svgDot{X: x(i), Y: y(*v), Tip: fmt.Sprintf("%s %s: %s", s.Name, c.Categories[i], c.Format(*v))})
+		}
+		line.Path = strings.TrimSpace(path.String())
+		out.Lines = append(out.Lines, line)
+	}
+	return out
+}
+
+// formatCount shortens large counts: 1234567 is 1.23M.
+func formatCount(v float64) string {
+	switch a := math.Abs(v); {
+	case a >= 1e9:
+		return strconv.FormatFloat(v/1e9, 'f', 2, 64) + "B"
+	case a >= 1e6:
+		return strconv.FormatFloat(v/1e6, 'f', 2, 64) + "M"
+	case a >= 1e4:
+		return strconv.FormatFloat(v/1e3, 'f', 1, 64) + "k"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatPercent(v float64) string {
+	return strconv.FormatFloat(v*100, 'f', 1, 64) + "%"
+}
+
+// kpi is one headline number.
+type kpi struct {
+	Label, Value string
+}
+
+// dashTable is a sortable table.
+type dashTable struct {
+	Title   string
+	Columns []string
+	Rows    [][]htmlCell
+}
+
+type dashboard struct {
+	Title     string
+	Generated string
+	Sources   []string
+	KPIs      []kpi
+	Charts    []svgChart
+	Tables    []dashTable
+}
+
+func floatPtrIf(v float64, ok bool) *float64 {
+	if !ok {
+		return nil
+	}
+	return &v
+}
+
+// buildDashboard reads the exports and lays out every chart and table.
+// Time series show the top packages by installs; day is the cohort day
+// used for revenue and ROAS.
+func buildDashboard(paths []string, top, day int) (*dashboard, error) {
+	total := newReportBuilder(reportOptions{Decimals: defaultReportDecimals})
+	byPackage := newReportBuilder(reportOptions{By: []string{"package"}, Sort: "install", Desc: true, Decimals: defaultReportDecimals})
+	byDate := newReportBuilder(reportOptions{By: []string{"date"}, Decimals: defaultReportDecimals})
+	byDatePackage := newReportBuilder(reportOptions{By: []string{"date", "package"}})
+	for _, path := range paths {
+		header, records, err := readExportFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range []*reportBuilder{total, byPackage, byDate, byDatePackage} {
+			if err := b.add(header, records); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+	days := total.sortedDays()
+	if len(days) > 0 && !total.days[day] {
+		day = days[len(days)-1]
+	}
+
+	d := &dashboard{Generated: time.Now().Format(time.RFC3339), Sources: paths}
+	dates := make([]string, 0, len(byDate.groups))
+	for _, g := range byDate.sortedGroups() {
+		dates = append(dates, g.dims[0])
+	}
+	d.Title = "Mobvista IAA dashboard"
+	if len(dates) > 0 {
+		d.Title += fmt.Sprintf(" %s to %s", dates[0], dates[len(dates)-1])
+	}
+
+	// KPIs
+	if groups := total.sortedGroups(); len(groups) == 1 {
+		g := groups[0]
+		d.KPIs = append(d.KPIs,
+			kpi{"Installs", formatCount(g.installs())},
+			kpi{"Impressions", formatCount(ratOrZero(g.sums["impressions"]))},
+			kpi{"Spend", formatCount(math.Round(g.spendTotal()))},
+		)
+		if v, ok := g.revenue(day); ok {
+			d.KPIs = append(d.KPIs, kpi{fmt.Sprintf("Revenue d%d", day), formatCount(math.Round(v))})
+		}
+		if v, ok := g.roas(day); ok {
+			d.KPIs = append(d.KPIs, kpi{fmt.Sprintf("ROAS d%d", day), formatPercent(v)})
+		}
+		for _, rd := range []int{1, 7} {
+			if v, ok := g.retention(rd); ok {
+				d.KPIs = append(d.KPIs, kpi{fmt.Sprintf("Retention d%d", rd), formatPercent(v)})
+			}
+		}
+		d.KPIs = append(d.KPIs, kpi{"Packages", strconv.Itoa(len(byPackage.groups))}, kpi{"Days", strconv.Itoa(len(dates))})
+	}
+
+	// The packages worth a line: most installs first
+	packages := byPackage.sortedGroups()
+	sort.SliceStable(packages, func(i, j int) bool { return packages[i].installs() > packages[j].installs() })
+	if len(packages) > top {
+		packages = packages[:top]
+	}
+	dateIndex := make(map[string]int, len(dates))
+	for i, date := range dates {
+		dateIndex[date] = i
+	}
+	series := func(metric func(*reportGroup) (float64, bool)) []chartSeries {
+		out := make([]chartSeries, len(packages))
+		pos := make(map[string]int, len(packages))
+		for i, p := range packages {
+			out[i] = chartSeries{Name: p.dims[0], Values: make([]*float64, len(dates))}
+			pos[p.dims[0]] = i
+		}
+		for _, g := range byDatePackage.groups {
+			if i, ok := pos[g.dims[1]]; ok {
+				out[i].Values[dateIndex[g.dims[0]]] = floatPtrIf(metric(g))
+			}
+		}
+		return out
+	}
+	charts := []lineChart{
+		{Title: "Installs per day", Categories: dates, Format: formatCount,
+			Series: series(func(g *reportGroup) (float64, bool) { return g.installs(), g.sums["install"] != nil })},
+		{Title: fmt.Sprintf("Revenue d%d per install date", day), Categories: dates, Format: formatCount,
+			Series: series(func(g *reportGroup) (float64, bool) { return g.revenue(day) })},
+		{Title: fmt.Sprintf("ROAS d%d per install date", day), Categories: dates, Format: formatPercent,
+			Series: series(func(g *reportGroup) (float64, bool) { return g.roas(day) })},
+	}
+	retention := lineChart{Title: "Retention curve (install weighted)", Format: formatPercent}
+	for _, rd := range days {
+		retention.Categories = append(retention.Categories, fmt.Sprintf("d%d", rd))
+	}
+	for _, p := range packages {
+		s := chartSeries{Name: p.dims[0]}
+		for _, rd := range days {
+			s.Values = append(s.Values, floatPtrIf(p.retention(rd)))
+		}
+		retention.Series = append(retention.Series, s)
+	}
+	charts = append(charts, retention)
+	for i := range charts {
+		if len(charts[i].Categories) > 0 {
+			d.Charts = append(d.Charts, charts[i].layout())
+		}
+	}
+
+	// Tables
+	for _, t := range []struct {
+		title string
+		b     *reportBuilder
+	}{{"By package", byPackage}, {"By install date", byDate}} {
+		rows, err := t.b.rows()
+		if err != nil {
+			return nil, err
+		}
+		table := dashTable{Title: t.title, Columns: t.b.columns()}
+		for _, row := range rows {
+			cells := make([]htmlCell, len(row))
+			for i, v := range row {
+				cells[i] = htmlCell{Value: v, Numeric: jsonNumber.MatchString(v)}
+			}
+			table.Rows = append(table.Rows, cells)
+		}
+		d.Tables = append(d.Tables, table)
+	}
+	return d, nil
+}
+
+func ratOrZero(v *big.Rat) float64 {
+	if v == nil {
+		return 0
+	}
+	f, _ := v.Float64()
+	return f
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 24px; color: #222; }
+h1 { font-size: 20px; margin-bottom: 4px; }
+h2 { font-size: 15px; margin: 28px 0 8px; }
+p.meta { color: #666; margin-top: 0; }
+.kpis { display: flex; flex-wrap: wrap; gap: 12px; }
+.kpi { border: 1px solid #ddd; border-radius: 6px; padding: 10px 16px; min-width: 110px; }
+.kpi .label { color: #666; font-size: 12px; }
+.kpi .value { font-size: 22px; font-variant-numeric: tabular-nums; }
+.charts { display: flex; flex-wrap: wrap; gap: 16px; }
+.chart { border: 1px solid #eee; padding: 8px; }
+.chart svg text { font-size: 10px; fill: #555; }
+.legend { font-size: 11px; max-width: 640px; }
+.legend span { display: inline-block; margin-right: 12px; }
+.legend i { display: inline-block; width: 10px; height: 10px; margin-right: 4px; }
+table { border-collapse: collapse; font-size: 12px; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; white-space: nowrap; }
+th { background: #f4f4f4; position: sticky; top: 0; cursor: pointer; user-select: none; }
+th[data-dir=asc]::after { content: " \25B2"; }
+th[data-dir=desc]::after { content: " \25BC"; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+tr:nth-child(even) td { background: #fafafa; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Generated {{.Generated}} from {{range $i, $s := .Sources}}{{if $i}}, {{end}}{{$s}}{{end}}</p>
+<div class="kpis">{{range .KPIs}}
+<div class="kpi"><div class="label">{{.Label}}</div><div class="value">{{.Value}}</div></div>{{end}}
+</div>
+<div class="charts">{{range .Charts}}{{$c := .}}
+<div class="chart">
+<h2>{{.Title}}</h2>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+{{range .YTicks}}<line x1="{{$c.Left}}" x2="{{$c.Right}}" y1="{{.Pos}}" y2="{{.Pos}}" stroke="#eee"/><text x="{{$c.Left}}" y="{{.Pos}}" dx="-4" dy="3" text-anchor="end">{{.Label}}</text>
+{{end}}{{range .XTicks}}<text x="{{.Pos}}" y="{{$c.Bottom}}" dy="14" text-anchor="middle">{{.Label}}</text>
+{{end}}<line x1="{{.Left}}" x2="{{.Right}}" y1="{{.Bottom}}" y2="{{.Bottom}}" stroke="#999"/>
+{{range .Lines}}<path d="{{.Path}}" fill="none" stroke="{{.Color}}" stroke-width="1.5"/>
+{{$color := .Color}}{{range .Dots}}<circle cx="{{.X}}" cy="{{.Y}}" r="2.5" fill="{{$color}}"><title>{{.Tip}}</title></circle>{{end}}
+{{end}}</svg>
+<div class="legend">{{range .Lines}}<span><i style="background: {{.Color}}"></i>{{.Name}}</span>{{end}}</div>
+</div>{{end}}
+</div>
+{{range .Tables}}
+<h2>{{.Title}}</h2>
+<table class="sortable">
+<thead><tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr>{{range .}}<td{{if .Numeric}} class="num"{{end}}>{{.Value}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+{{end}}
+<script>
+document.querySelectorAll("table.sortable").forEach(function (table) {
+  var headers = table.querySelectorAll("th");
+  headers.forEach(function (th, col) {
+    th.addEventListener("click", function () {
+      var asc = th.dataset.dir !== "asc";
+      headers.forEach(function (h) { delete h.dataset.dir; });
+      th.dataset.dir = asc ? "asc" : "desc";
+      var body = table.tBodies[0];
+      var rows = Array.prototype.slice.call(body.rows);
+      rows.sort(function (a, b) {
+        var x = a.cells[col].textContent, y = b.cells[col].textContent;
+        var nx = parseFloat(x), ny = parseFloat(y), c;
+        if (x === "" || y === "") c = (x === "") - (y === "");
+        else if (!isNaN(nx) && !isNaN(ny)) c = nx - ny;
+        else c = x.localeCompare(y);
+        return asc ? c : -c;
+      });
+      rows.forEach(function (r) { body.appendChild(r); });
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// runDashboard writes a self-contained HTML dashboard for exports.
+func runDashboard(args []string) {
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+	output := fs.String("o", defaultDashboardFile, "HTML file to write")
+	title := fs.String("title", "", "page title (default from the date range)")
+	top := fs.Int("top", defaultDashboardTop, fmt.Sprintf("packages drawn in the charts, by installs (at most %d)", len(chartColors)))
+	day := fs.Int("day", defaultDashboardDay, "cohort day used for revenue and ROAS (falls back to the latest day present)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s dashboard [flags] EXPORT...\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || *top < 1 || *top > len(chartColors) || *day < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	d, err := buildDashboard(fs.Args(), *top, *day)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	d.Title = firstNonEmpty(*title, d.Title)
+	err = writeFileAtomic(*output, func(w io.Writer) error {
+		return dashboardTemplate.Execute(w, d)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "writing dashboard failed:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Dashboard with %d charts written to %s\n", len(d.Charts), *output)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDashboard_Build(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.csv")
+	csv := "date,channel_id,offer_id,package,install,impressions,rr_d1,rr_d7,d7_roas,revenue_d7\n" +
+		"2025-07-07,1,10,com.a,100,1000,0.5,0.2,0.5,50\n" +
+		"2025-07-08,1,11,com.a,300,2000,0.3,0.1,0.25,100\n" +
+		"2025-07-07,2,12,<b>com.b</b>,50,500,0.4,,,\n"
+	if err := os.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := buildDashboard([]string{path}, 8, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kpis := make(map[string]string)
+	for _, k := range d.KPIs {
+		kpis[k.Label] = k.Value
+	}
+	// spend 100 + 400 from install/ROAS; revenue 150 over it is 30%
+	for label, want := range map[string]string{"Installs": "450", "Spend": "500", "Revenue d7": "150", "ROAS d7": "30.0%", "Packages": "2", "Days": "2"} {
+		if kpis[label] != want {
+			t.Errorf("Expected KPI %s = %s, got %q", label, want, kpis[label])
+		}
+	}
+	if len(d.Charts) != 4 || len(d.Tables) != 2 {
+		t.Fatalf("Expected 4 charts and 2 tables, got %d and %d", len(d.Charts), len(d.Tables))
+	}
+	// com.b has no revenue at all and no row on 07-08: a line without dots
+	revenue := d.Charts[1]
+	if len(revenue.Lines) != 2 || len(revenue.Lines[0].Dots) != 2 || len(revenue.Lines[1].Dots) != 0 {
+		t.Errorf("Unexpected revenue lines %+v", revenue.Lines)
+	}
+	if installs := d.Charts[0].Lines[1]; len(installs.Dots) != 1 || strings.Contains(installs.Path, "L") {
+		t.Errorf("Expected a single point for com.b installs, got %q", installs.Path)
+	}
+
+	var page bytes.Buffer
+	if err := dashboardTemplate.Execute(&page, d); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(page.String(), "<b>com.b</b>") || !strings.Contains(page.String(), "&lt;b&gt;com.b&lt;/b&gt;") {
+		t.Error("Expected package names to be escaped")
+	}
+}
+
+func TestDashboard_Axis(t *testing.T) {
+	for v, want := range map[float64]float64{0: 1, 0.37: 0.5, 1: 1, 1.2: 2, 2.2: 2.5, 7: 10, 4100: 5000} {
+		if got := niceCeil(v); got != want {
+			t.Errorf("niceCeil(%v): expected %v, got %v", v, want, got)
+		}
+	}
+	for v, want := range map[float64]string{950: "950", 12345: "12.3k", 2500000: "2.50M", 3e9: "3.00B"} {
+		if got := formatCount(v); got != want {
+			t.Errorf("formatCount(%v): expected %s, got %s", v, want, got)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+	_ "time/tzdata" // servers and containers often ship without zoneinfo
+)
+
+const (
+	dateLayout      = "2006-01-02"
+	defaultDays     = 7
+	defaultTimezone = "Asia/Shanghai"
+)
+
+// DateRange is an inclusive range of report dates. Start and End are
+// midnight in Location, the timezone the report is bucketed in.
+type DateRange struct {
+	Start    time.Time
+	End      time.Time
+	Location *time.Location
+}
+
+func (r DateRange) StartDate() string { return r.Start.Format(dateLayout) }
+func (r DateRange) EndDate() string   { return r.End.Format(dateLayout) }
+
+// Days is the number of dates in the range, counting both ends.
+func (r DateRange) Days() int {
+	return daysBetween(r.Start, r.End) + 1
+}
+
+// Dates lists every date in the range in order.
+func (r DateRange) Dates() []string {
+	dates := make([]string, 0, r.Days())
+	for d := r.Start; !d.After(r.End); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format(dateLayout))
+	}
+	return dates
+}
+
+func (r DateRange) String() string {
+	return fmt.Sprintf("%s to %s (%s)", r.StartDate(), r.EndDate(), r.Location)
+}
+
+// daysBetween counts calendar days rather than dividing durations, which
+// would be off by one across a DST change.
+func daysBetween(a, b time.Time) int {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	ua := time.Date(ay, am, ad, 0, 0, 0, 0, time.UTC)
+	ub := time.Date(by, bm, bd, 0, 0, 0, 0, time.UTC)
+	return int(ub.Sub(ua).Hours() / 24)
+}
+
+type dateFlags struct {
+	fs    *flag.FlagSet
+	start string
+	end   string
+	days  int
+	tz    string
+}
+
+func registerDateFlags(fs *flag.FlagSet) *dateFlags {
+	f := &dateFlags{fs: fs}
+	fs.StringVar(&f.start, "start", "", "first report date, YYYY-MM-DD (default end minus -days)")
+	fs.StringVar(&f.end, "end", "", "last report date, YYYY-MM-DD (default today in -tz)")
+	fs.IntVar(&f.days, "days", defaultDays, "number of days in the window, counting today")
+	fs.StringVar(&f.tz, "tz", defaultTimezone, "timezone the report dates are in, e.g. UTC or Asia/Shanghai")
+	return f
+}
+
+// resolve turns the flags into a DateRange relative to now. "Today" is
+// taken in the report timezone, so the same flags give the same window on
+// any host.
+func (f *dateFlags) resolve(now time.Time) (DateRange, error) {
+	daysSet := false
+	f.fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == "days" {
+			daysSet = true
+		}
+	})
+	return resolveDateRange(f.start, f.end, f.days, daysSet, f.tz, now)
+}
+
+func resolveDateRange(start, end string, days int, daysSet bool, tz string, now time.Time) (DateRange, error) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return DateRange{}, fmt.Errorf("invalid timezone %q: %v", tz, err)
+	}
+	if days < 1 {
+		return DateRange{}, fmt.Errorf("days must be at least 1, got %d", days)
+	}
+	if start != "" && end != "" && daysSet {
+		return DateRange{}, errors.New("use at most two of -start, -end and -days")
+	}
+
+	y, m, d := now.In(loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, loc)
+
+	r := DateRange{Location: loc}
+	switch {
+	case start != "" && end != "":
+		if r.Start, err = parseDate(start, loc); err != nil {
+			return DateRange{}, err
+		}
+		if r.End, err = parseDate(end, loc); err != nil {
+			return DateRange{}, err
+		}
+	case start != "":
+		if r.Start, err = parseDate(start, loc); err != nil {
+			return DateRange{}, err
+		}
+		r.End = today
+		if daysSet {
+			r.End = r.Start.AddDate(0, 0, days-1)
+		}
+	default:
+		r.End = today
+		if end != "" {
+			if r.End, err = parseDate(end, loc); err != nil {
+				return DateRange{}, err
+			}
+		}
+		r.Start = r.End.AddDate(0, 0, -(days - 1))
+	}
+
+	if r.End.Before(r.Start) {
+		return DateRange{}, fmt.Errorf("inverted date range: start %s is after end %s", r.StartDate(), r.EndDate())
+	}
+	if r.Start.After(today) {
+		return DateRange{}, fmt.Errorf("start %s is in the future (today is %s in %s)", r.StartDate(), today.Format(dateLayout), loc)
+	}
+	return r, nil
+}
+
+func parseDate(s string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation(dateLayout, s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: want YYYY-MM-DD", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveDateRange_UsesReportTimezone(t *testing.T) {
+	// 20:00 UTC on Jul 10 is already Jul 11 in Shanghai
+	now := time.Date(2025, 7, 10, 20, 0, 0, 0, time.UTC)
+
+	r, err := resolveDateRange("", "", 7, false, "Asia/Shanghai", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.StartDate() != "2025-07-05" || r.EndDate() != "2025-07-11" {
+		t.Errorf("Expected 2025-07-05..2025-07-11, got %s..%s", r.StartDate(), r.EndDate())
+	}
+
+	r, err = resolveDateRange("", "", 7, false, "UTC", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.StartDate() != "2025-07-04" || r.EndDate() != "2025-07-10" {
+		t.Errorf("Expected 2025-07-04..2025-07-10, got %s..%s", r.StartDate(), r.EndDate())
+	}
+}
+
+func TestResolveDateRange_Explicit(t *testing.T) {
+	now := time.Date(2025, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	r, err := resolveDateRange("2025-07-04", "", 3, true, "UTC", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.EndDate() != "2025-07-06" || r.Days() != 3 {
+		t.Errorf("Expected end 2025-07-06 and 3 days, got %s and %d", r.EndDate(), r.Days())
+	}
+	if dates := r.Dates(); len(dates) != 3 || dates[2] != "2025-07-06" {
+		t.Errorf("unexpected dates %v", dates)
+	}
+}
+
+func TestResolveDateRange_Rejects(t *testing.T) {
+	now := time.Date(2025, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name       string
+		start, end string
+		days       int
+		daysSet    bool
+		tz         string
+	}{
+		{"inverted", "2025-07-11", "2025-07-04", 7, false, "UTC"},
+		{"bad date", "2025-7-4", "", 7, false, "UTC"},
+		{"bad timezone", "", "", 7, false, "Mars/Olympus"},
+		{"zero days", "", "", 0, true, "UTC"},
+		{"future start", "2025-08-01", "2025-08-02", 7, false, "UTC"},
+		{"overdetermined", "2025-07-01", "2025-07-02", 2, true, "UTC"},
+	}
+	for _, c := range cases {
+		if _, err := resolveDateRange(c.start, c.end, c.days, c.daysSet, c.tz, now); err == nil {
+			t.Errorf("%s: expected error, got nil", c.name)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// diffKey identifies a row across exports, using masterKeyColumns plus
+// the account of a merged -accounts export.
+type diffKey struct {
+	Account   string `json:"account,omitempty"`
+	Date      string `json:"date"`
+	ChannelID string `json:"channel_id"`
+	OfferID   string `json:"offer_id"`
+}
+
+func (k diffKey) String() string {
+	s := k.Date + " channel " + k.ChannelID + " offer " + k.OfferID
+	if k.Account != "" {
+		s = "account " + k.Account + " " + s
+	}
+	return s
+}
+
+// metricChange is one column that differs between the two versions of a
+// row. Abs and Rel are only set for numeric values; Rel is nil when the
+// old value is zero.
+type metricChange struct {
+	Metric string   `json:"metric"`
+	Old    string   `json:"old"`
+	New    string   `json:"new"`
+	Abs    *float64 `json:"abs_delta,omitempty"`
+	Rel    *float64 `json:"rel_delta,omitempty"`
+
+	absText string // exact difference, for text and CSV output
+}
+
+type rowChange struct {
+	Key     diffKey        `json:"key"`
+	Changes []metricChange `json:"changes"`
+}
+
+// exportDiff is the result of comparing an old and a new export.
+type exportDiff struct {
+	Old        string      `json:"old"`
+	New        string      `json:"new"`
+	OnlyInOld  []string    `json:"columns_only_in_old,omitempty"`
+	OnlyInNew  []string    `json:"columns_only_in_new,omitempty"`
+	Added      []diffKey   `json:"added"`
+	Removed    []diffKey   `json:"removed"`
+	Changed    []rowChange `json:"changed"`
+	Unchanged  int         `json:"unchanged"`
+	BelowLimit int         `json:"below_threshold"` // rows whose changes were all filtered out
+}
+
+func (d *exportDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d *exportDiff) summary() string {
+	return fmt.Sprintf("%d added, %d removed, %d changed, %d unchanged, %d below threshold",
+		len(d.Added), len(d.Removed), len(d.Changed), d.Unchanged, d.BelowLimit)
+}
+
+// diffOptions filter what counts as a change. A numeric change is kept
+// when it reaches both MinAbs and MinRel (a fraction, 0.05 = 5%); text
+// changes are always kept.
+type diffOptions struct {
+	MinAbs  float64
+	MinRel  float64
+	Metrics []string // compare only these columns; all shared columns when empty
+}
+
+// exportTable is an export file read into memory, keyed for joining.
+type exportTable struct {
+	header  []string
+	index   map[string]int
+	keyCols []string // masterKeyColumns, with account first when present
+	rows    map[string][]string
+	keys    map[string]diffKey
+}
+
+// readExportFile reads a CSV or TSV export (by extension), tolerating the
+// UTF-8 BOM written with -bom. It returns the header and the data rows.
+func readExportFile(path string) ([]string, [][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\ufeff" {
+		br.Discard(3)
+	}
+	reader := csv.NewReader(br)
+	if strings.EqualFold(filepath.Ext(path), ".tsv") {
+		reader.Comma = '\t'
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("%s: empty file", path)
+	}
+	return records[0], records[1:], nil
+}
+
+// readExportTable reads an export and keys its rows by masterKeyColumns.
+// A merged -accounts export repeats those keys once per account, so its
+// account column becomes part of the key.
+func readExportTable(path string) (*exportTable, error) {
+	header, records, err := readExportFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &exportTable{
+		header: header,
+		index:  make(map[string]int, len(header)),
+		rows:   make(map[string][]string, len(records)),
+		keys:   make(map[string]diffKey, len(records)),
+	}
+	for i, column := range t.header {
+		t.index[column] = i
+	}
+	t.keyCols = masterKeyColumns
+	if _, ok := t.index[accountColumn.Name]; ok {
+		t.keyCols = append([]string{accountColumn.Name}, masterKeyColumns...)
+	}
+	keyIndex, err := columnIndexes(t.header, t.keyCols)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, record := range records {
+		key := recordKey(record, keyIndex)
+		if _, dup := t.rows[key]; dup {
+			return nil, fmt.Errorf("%s: duplicate row for %s", path, strings.ReplaceAll(key, "\x1f", "/"))
+		}
+		t.rows[key] = record
+		i := keyIndex[len(keyIndex)-3:]
+		k := diffKey{Date: record[i[0]], ChannelID: record[i[1]], OfferID: record[i[2]]}
+		if len(keyIndex) > 3 {
+			k.Account = record[keyIndex[0]]
+		}
+		t.keys[key] = k
+	}
+	return t, nil
+}
+
+func (t *exportTable) value(record []string, column string) string {
+	if i, ok := t.index[column]; ok && i < len(record) {
+		return record[i]
+	}
+	return ""
+}
+
+// diffTables joins before and after on the key columns and compares every
+// column they share.
+func diffTables(before, after *exportTable, opts diffOptions) (*exportDiff, error) {
+	d := &exportDiff{Added: []diffKey{}, Removed: []diffKey{}, Changed: []rowChange{}}
+	for _, column := range before.header {
+		if _, ok := after.index[column]; !ok {
+			d.OnlyInOld = append(d.OnlyInOld, column)
+		}
+	}
+	for _, column := range after.header {
+		if _, ok := before.index[column]; !ok {
+			d.OnlyInNew = append(d.OnlyInNew, column)
+		}
+	}
+
+	metrics := opts.Metrics
+	if len(metrics) == 0 {
+		for _, column := range after.header {
+			if _, ok := before.index[column]; ok && !contains(before.keyCols, column) && !contains(after.keyCols, column) {
+				metrics = append(metrics, column)
+			}
+		}
+	}
+	for _, metric := range metrics {
+		_, inOld := before.index[metric]
+		_, inNew := after.index[metric]
+		if !inOld || !inNew {
+			return nil, fmt.Errorf("column %q is not in both files", metric)
+		}
+	}
+
+	keys := make([]string, 0, len(before.rows)+len(after.rows))
+	for key := range before.rows {
+		keys = append(keys, key)
+	}
+	for key := range after.rows {
+		if _, ok := before.rows[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		oldRecord, inOld := before.rows[key]
+		newRecord, inNew := after.rows[key]
+		switch {
+		case !inOld:
+			d.Added = append(d.Added, after.keys[key])
+			continue
+		case !inNew:
+			d.Removed = append(d.Removed, before.keys[key])
+			continue
+		}
+
+		var changes []metricChange
+		differs := false
+		for _, metric := range metrics {
+			change, ok := compareValues(metric, before.value(oldRecord, metric), after.value(newRecord, metric))
+			if !ok {
+				continue
+			}
+			differs = true
+			if change.Abs != nil && (math.Abs(*change.Abs) < opts.MinAbs || (change.Rel != nil && math.Abs(*change.Rel) < opts.MinRel)) {
+				continue
+			}
+			changes = append(changes, change)
+		}
+		switch {
+		case len(changes) > 0:
+			d.Changed = append(d.Changed, rowChange{Key: after.keys[key], Changes: changes})
+		case differs:
+			d.BelowLimit++
+		default:
+			d.Unchanged++
+		}
+	}
+	return d, nil
+}
+
+// compareValues reports whether a and b differ. Numbers are compared by
+// value, so "0.10" and "0.1" are the same.
+func compareValues(metric, a, b string) (metricChange, bool) {
+	if a == b {
+		return metricChange{}, false
+	}
+	change := metricChange{Metric: metric, Old: a, New: b}
+	ra, okA := new(big.Rat).SetString(a)
+	rb, okB := new(big.Rat).SetString(b)
+	if !okA || !okB || textColumns[metric] {
+		return change, true
+	}
+	if ra.Cmp(rb) == 0 {
+		return metricChange{}, false
+	}
+	delta := new(big.Rat).Sub(rb, ra)
+	abs, _ := delta.Float64()
+	change.Abs = &abs
+	change.absText = delta.FloatString(max(decimalPlaces(a), decimalPlaces(b)))
+	if ra.Sign() != 0 {
+		rel, _ := new(big.Rat).Quo(delta, new(big.Rat).Abs(ra)).Float64()
+		change.Rel = &rel
+	}
+	return change, true
+}
+
+func decimalPlaces(s string) int {
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+func writeDiffText(w io.Writer, d *exportDiff) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "--- %s\n+++ %s\n", d.Old, d.New)
+	if len(d.OnlyInOld) > 0 {
+		fmt.Fprintf(bw, "columns only in old: %s\n", strings.Join(d.OnlyInOld, ", "))
+	}
+	if len(d.OnlyInNew) > 0 {
+		fmt.Fprintf(bw, "columns only in new: %s\n", strings.Join(d.OnlyInNew, ", "))
+	}
+	for _, key := range d.Removed {
+		fmt.Fprintf(bw, "- %s\n", key)
+	}
+	for _, key := range d.Added {
+		fmt.Fprintf(bw, "+ %s\n", key)
+	}
+	for _, row := range d.Changed {
+		fmt.Fprintf(bw, "~ %s\n", row.Key)
+		for _, c := range row.Changes {
+			fmt.Fprintf(bw, "    %-12s %s -> %s", c.Metric, c.Old, c.New)
+			if c.Abs != nil {
+				fmt.Fprintf(bw, " (%s", signed(c.absText))
+				if c.Rel != nil {
+					fmt.Fprintf(bw, ", %+.2f%%", *c.Rel*100)
+				}
+				bw.WriteString(")")
+			}
+			bw.WriteString("\n")
+		}
+	}
+	fmt.Fprintln(bw, d.summary())
+	return bw.Flush()
+}
+
+func signed(s string) string {
+	if strings.HasPrefix(s, "-") {
+		return s
+	}
+	return "+" + s
+}
+
+// writeDiffCSV writes one line per added or removed row and one per
+// changed metric.
+func writeDiffCSV(w io.Writer, d *exportDiff) error {
+	writer := csv.NewWriter(w)
+	// the account column only appears when the exports were merged ones
+	withAccount := false
+	for _, keys := range [][]diffKey{d.Removed, d.Added} {
+		for _, k := range keys {
+			withAccount = withAccount || k.Account != ""
+		}
+	}
+	for _, row := range d.Changed {
+		withAccount = withAccount || row.Key.Account != ""
+	}
+	header := []string{"change", "date", "channel_id", "offer_id", "metric", "old", "new", "abs_delta", "rel_delta"}
+	keyFields := func(k diffKey) []string { return []string{k.Date, k.ChannelID, k.OfferID} }
+	if withAccount {
+		header = append([]string{"change", "account"}, header[1:]...)
+		keyFields = func(k diffKey) []string { return []string{k.Account, k.Date, k.ChannelID, k.OfferID} }
+	}
+	writer.Write(header)
+	for _, key := range d.Removed {
+		writer.Write(append(append([]string{"removed"}, keyFields(key)...), "", "", "", "", ""))
+	}
+	for _, key := range d.Added {
+		writer.Write(append(append([]string{"added"}, keyFields(key)...), "", "", "", "", ""))
+	}
+	for _, row := range d.Changed {
+		for _, c := range row.Changes {
+			rel := ""
+			if c.Rel != nil {
+				rel = fmt.Sprintf("%.6f", *c.Rel)
+			}
+			writer.Write(append(append([]string{"changed"}, keyFields(row.Key)...), c.Metric, c.Old, c.New, c.absText, rel))
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeDiffJSON(w io.Writer, d *exportDiff) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+var diffWriters = map[string]func(io.Writer, *exportDiff) error{
+	"text": writeDiffText,
+	"csv":  writeDiffCSV,
+	"json": writeDiffJSON,
+}
+
+// runDiff compares two exports of the same window, typically taken before
+// and after the network restated some days.
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := fs.String("format", "text", "output format: text, csv or json")
+	output := fs.String("o", "", "write the diff to this file instead of stdout")
+	minAbs := fs.Float64("min-abs", 0, "ignore numeric changes smaller than this absolute amount")
+	minRel := fs.Float64("min-rel", 0, "ignore numeric changes smaller than this fraction of the old value (0.05 = 5%)")
+	metrics := fs.String("metrics", "", "comma-separated columns to compare (default every column both files share)")
+	exitCodeFlag := fs.Bool("exit-code", false, "exit with status 1 when there are differences, like diff(1); errors always exit 2")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff [flags] OLD NEW\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	write, ok := diffWriters[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q (want text, csv or json)\n", *format)
+		os.Exit(2)
+	}
+	if fs.NArg() != 2 || *minAbs < 0 || *minRel < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	opts := diffOptions{MinAbs: *minAbs, MinRel: *minRel, Metrics: splitList(*metrics)}
+
+	// Exit status follows diff(1): 0 same, 1 different, 2 trouble.
+	before, err := readExportTable(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	after, err := readExportTable(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	d, err := diffTables(before, after, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	d.Old, d.New = fs.Arg(0), fs.Arg(1)
+
+	if *output == "" {
+		err = write(os.Stdout, d)
+	} else {
+		err = writeFileAtomic(*output, func(w io.Writer) error { return write(w, d) })
+		if err == nil {
+			fmt.Printf("Diff written to %s: %s\n", *output, d.summary())
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "writing diff failed:", err)
+		os.Exit(2)
+	}
+	if *exitCodeFlag && !d.Empty() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTable(t *testing.T, name, content string) *exportTable {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := readExportTable(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	return table
+}
+
+const diffOld = "\ufeffdate,channel_id,offer_id,package,install,revenue_d7\n" +
+	"2025-07-04,1,10,com.a,100,5.00\n" +
+	"2025-07-04,1,11,com.a,200,8.5\n" +
+	"2025-07-05,1,10,com.a,0,0\n" +
+	"2025-07-05,1,12,com.b,50,1.25\n"
+
+const diffNew = "date,channel_id,offer_id,package,install,revenue_d7\n" +
+	"2025-07-04,1,10,com.a,100,5\n" +
+	"2025-07-04,1,11,com.a,210,8.4\n" +
+	"2025-07-05,1,10,com.c,3,0\n" +
+	"2025-07-06,1,10,com.a,70,2\n"
+
+func TestDiffTables(t *testing.T) {
+	d, err := diffTables(writeTable(t, "old.csv", diffOld), writeTable(t, "new.csv", diffNew), diffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Added) != 1 || d.Added[0].Date != "2025-07-06" || len(d.Removed) != 1 || d.Removed[0].OfferID != "12" {
+		t.Errorf("Unexpected added/removed: %+v %+v", d.Added, d.Removed)
+	}
+	if d.Unchanged != 1 || len(d.Changed) != 2 {
+		t.Fatalf("Expected 1 unchanged (5.00 == 5) and 2 changed, got %s", d.summary())
+	}
+
+	changes := d.Changed[0].Changes
+	if len(changes) != 2 || changes[0].Metric != "install" || *changes[0].Abs != 10 || *changes[0].Rel != 0.05 {
+		t.Errorf("Unexpected install change: %+v", changes[0])
+	}
+	if changes[1].absText != "-0.1" {
+		t.Errorf("Expected exact revenue delta -0.1, got %s", changes[1].absText)
+	}
+	// old install 0: no relative delta; package is text
+	changes = d.Changed[1].Changes
+	if changes[0].Metric != "package" || changes[0].Abs != nil || changes[1].Rel != nil {
+		t.Errorf("Unexpected changes from zero/text values: %+v", changes)
+	}
+}
+
+func TestDiffTables_Threshold(t *testing.T) {
+	d, err := diffTables(writeTable(t, "old.csv", diffOld), writeTable(t, "new.csv", diffNew), diffOptions{MinRel: 0.05, Metrics: []string{"install", "revenue_d7"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// install +5% passes, revenue -1.2% does not; install from 0 has no
+	// relative delta and is kept
+	if len(d.Changed) != 2 || len(d.Changed[0].Changes) != 1 || d.BelowLimit != 0 {
+		t.Errorf("Unexpected filtered diff: %s %+v", d.summary(), d.Changed)
+	}
+
+	d, _ = diffTables(writeTable(t, "old.csv", diffOld), writeTable(t, "new.csv", diffNew), diffOptions{MinAbs: 50, Metrics: splitList(" install , ")})
+	if len(d.Changed) != 0 || d.BelowLimit != 2 {
+		t.Errorf("Expected both install changes below threshold, got %s", d.summary())
+	}
+}
+
+func TestDiffWriters(t *testing.T) {
+	d, _ := diffTables(writeTable(t, "old.csv", diffOld), writeTable(t, "new.csv", diffNew), diffOptions{})
+	var text, csvOut, jsonOut bytes.Buffer
+	writeDiffText(&text, d)
+	writeDiffCSV(&csvOut, d)
+	writeDiffJSON(&jsonOut, d)
+
+	if !strings.Contains(text.String(), "install      200 -> 210 (+10, +5.00%)") {
+		t.Errorf("Unexpected text diff:\n%s", text.String())
+	}
+	if !strings.Contains(csvOut.String(), "changed,2025-07-04,1,11,revenue_d7,8.5,8.4,-0.1,-0.011765") {
+		t.Errorf("Unexpected CSV diff:\n%s", csvOut.String())
+	}
+	var decoded exportDiff
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil || len(decoded.Changed) != 2 {
+		t.Errorf("Unexpected JSON diff (%v):\n%s", err, jsonOut.String())
+	}
+}
+
+func TestReadExportTable_DuplicateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dup.csv")
+	os.WriteFile(path, []byte("date,channel_id,offer_id\n2025-07-04,1,1\n2025-07-04,1,1\n"), 0644)
+	if _, err := readExportTable(path); err == nil {
+		t.Error("Expected duplicate keys to be rejected")
+	}
+}
+
+func TestDiffTables_MergedAccounts(t *testing.T) {
+	before := "account,date,channel_id,offer_id,install\n" +
+		"eu,2025-07-04,1,10,100\n" +
+		"us,2025-07-04,1,10,300\n"
+	after := "account,date,channel_id,offer_id,install\n" +
+		"eu,2025-07-04,1,10,100\n" +
+		"us,2025-07-04,1,10,330\n" +
+		"apac,2025-07-04,1,10,5\n"
+	d, err := diffTables(writeTable(t, "old.csv", before), writeTable(t, "new.csv", after), diffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Unchanged != 1 || len(d.Changed) != 1 || len(d.Added) != 1 {
+		t.Fatalf("Expected one row per account, got %s", d.summary())
+	}
+	if d.Changed[0].Key.Account != "us" || len(d.Changed[0].Changes) != 1 || d.Changed[0].Changes[0].Metric != "install" {
+		t.Errorf("Unexpected change: %+v", d.Changed[0])
+	}
+	if d.Added[0].Account != "apac" {
+		t.Errorf("Expected apac added, got %+v", d.Added[0])
+	}
+	var out bytes.Buffer
+	writeDiffCSV(&out, d)
+	if !strings.Contains(out.String(), "changed,us,2025-07-04,1,10,install,300,330") {
+		t.Errorf("Expected the account in the CSV diff:\n%s", out.String())
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Exporter writes one export, a header followed by rows, in a particular
+// file format. Rows arrive already formatted as strings in column order.
+type Exporter interface {
+	Begin(columns []string) error
+	WriteRow(values []string) error
+	End() error
+}
+
+// ExportOptions are the settings shared by every output format.
+type ExportOptions struct {
+	Format string
+	BOM    bool   // prefix CSV/TSV output with a UTF-8 byte order mark for Excel
+	Title  string // heading used by the HTML report
+}
+
+// outputFormats maps a -format value to its file extension.
+var outputFormats = map[string]string{
+	"csv":   "csv",
+	"tsv":   "tsv",
+	"jsonl": "jsonl",
+	"html":  "html",
+}
+
+func formatNames() string {
+	names := make([]string, 0, len(outputFormats))
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func newExporter(w io.Writer, opts ExportOptions) (Exporter, error) {
+	switch opts.Format {
+	case "csv":
+		return newDelimitedExporter(w, ',', opts.BOM), nil
+	case "tsv":
+		return newDelimitedExporter(w, '\t', opts.BOM), nil
+	case "jsonl":
+		return &jsonlExporter{w: bufio.NewWriter(w)}, nil
+	case "html":
+		return &htmlExporter{w: bufio.NewWriter(w), title: opts.Title}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q (want one of %s)", opts.Format, formatNames())
+}
+
+// exportFile writes rows to filename in the chosen format. The file is
+// written under a temporary name and renamed into place when complete.
+func exportFile(filename string, columns []Column, rows []*IAARow, formatOpts FormatOptions, opts ExportOptions) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		exporter, err := newExporter(w, opts)
+		if err != nil {
+			return err
+		}
+		if err := exporter.Begin(columnNames(columns)); err != nil {
+			return err
+		}
+		values := make([]string, len(columns))
+		for _, row := range rows {
+			for i, column := range columns {
+				values[i] = column.Value(row, formatOpts)
+			}
+			if err := exporter.WriteRow(values); err != nil {
+				return err
+			}
+		}
+		return exporter.End()
+	})
+}
+
+// delimitedExporter covers CSV and TSV. TSV is written with encoding/csv
+// too so that values containing tabs or newlines are quoted, not mangled.
+type delimitedExporter struct {
+	w   io.Writer
+	csv *csv.Writer
+	bom bool
+}
+
+func newDelimitedExporter(w io.Writer, comma rune, bom bool) *delimitedExporter {
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+	return &delimitedExporter{w: w, csv: writer, bom: bom}
+}
+
+func (e *delimitedExporter) Begin(columns []string) error {
+	if e.bom {
+		if _, err := io.WriteString(e.w, "\ufeff"); err != nil {
+			return err
+		}
+	}
+	return e.csv.Write(columns)
+}
+
+func (e *delimitedExporter) WriteRow(values []string) error {
+	return e.csv.Write(values)
+}
+
+func (e *delimitedExporter) End() error {
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+// textColumns are always written as JSON strings even when they look
+// numeric, e.g. a date-like package name or a numeric uuid.
+var textColumns = map[string]bool{
+	"date":         true,
+	"channel_uuid": true,
+	"offer_uuid":   true,
+	"package":      true,
+}
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// jsonlExporter writes one JSON object per row. Numeric columns are emitted
+// as JSON numbers using the formatted text, so precision settings carry
+// through; empty values become null.
+type jsonlExporter struct {
+	w       *bufio.Writer
+	columns []string
+}
+
+func (e *jsonlExporter) Begin(columns []string) error {
+	e.columns = append([]string(nil), columns...)
+	return nil
+}
+
+func (e *jsonlExporter) WriteRow(values []string) error {
+	e.w.WriteByte('{')
+	for i, column := range e.columns {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(column)
+		e.w.Write(key)
+		e.w.WriteByte(':')
+		e.w.Write(jsonValue(column, values[i]))
+	}
+	_, err := e.w.WriteString("}\n")
+	return err
+}
+
+// jsonValue encodes one formatted value the way jsonlExporter does.
+func jsonValue(column, v string) json.RawMessage {
+	switch {
+	case v == "":
+		return json.RawMessage("null")
+	case !textColumns[column] && jsonNumber.MatchString(v):
+		return json.RawMessage(v)
+	}
+	quoted, _ := json.Marshal(v)
+	return quoted
+}
+
+func (e *jsonlExporter) End() error {
+	return e.w.Flush()
+}
+
+var htmlHead = template.Must(template.New("head").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 24px; color: #222; }
+h1 { font-size: 20px; margin-bottom: 4px; }
+p.meta { color: #666; margin-top: 0; }
+table { border-collapse: collapse; font-size: 12px; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; white-space: nowrap; }
+th { background: #f4f4f4; position: sticky; top: 0; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+tr:nth-child(even) td { background: #fafafa; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Generated {{.Generated}}</p>
+<table>
+<thead><tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+`))
+
+var htmlRow = template.Must(template.New("row").Parse(
+	`<tr>{{range .}}<td{{if .Numeric}} class="num"{{end}}>{{.Value}}</td>{{end}}</tr>
+`))
+
+// htmlExporter writes a standalone HTML page with the rows as a table. It
+// has no external assets so the file can be mailed or opened offline.
+type htmlExporter struct {
+	w     *bufio.Writer
+	title string
+	count int
+}
+
+type htmlCell struct {
+	Value   string
+	Numeric bool
+}
+
+func (e *htmlExporter) Begin(columns []string) error {
+	title := e.title
+	if title == "" {
+		title = "Mobvista IAA report"
+	}
+	return htmlHead.Execute(e.w, map[string]interface{}{
+		"Title":     title,
+		"Generated": time.Now().Format(time.RFC3339),
+		"Columns":   columns,
+	})
+}
+
+func (e *htmlExporter) WriteRow(values []string) error {
+	cells := make([]htmlCell, len(values))
+	for i, v := range values {
+		cells[i] = htmlCell{Value: v, Numeric: jsonNumber.MatchString(v)}
+	}
+	e.count++
+	return htmlRow.Execute(e.w, cells)
+}
+
+func (e *htmlExporter) End() error {
+	fmt.Fprintf(e.w, "</tbody>\n</table>\n<p class=\"meta\">%d rows</p>\n</body>\n</html>\n", e.count)
+	return e.w.Flush()
+}
+
+var placeholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// expandPattern fills {name} placeholders in an output filename pattern.
+// Unknown placeholders are an error rather than being left in the name.
+func expandPattern(pattern string, vars map[string]string) (string, error) {
+	var missing []string
+	out := placeholder.ReplaceAllStringFunc(pattern, func(m string) string {
+		name := m[1 : len(m)-1]
+		v, ok := vars[name]
+		if !ok {
+			missing = append(missing, m)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("unknown placeholder %s in output pattern %q", strings.Join(missing, ", "), pattern)
+	}
+	return out, nil
+}
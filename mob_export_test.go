@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// exportString runs rows through a fresh exporter for opts.
+func exportString(t *testing.T, opts ExportOptions, columns []string, rows ...[]string) string {
+	t.Helper()
+	var out bytes.Buffer
+	exporter, err := newExporter(&out, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Begin(columns); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := exporter.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exporter.End(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestDelimitedExporter(t *testing.T) {
+	columns := []string{"package", "install"}
+	cases := []struct {
+		opts ExportOptions
+		row  []string
+		want string
+	}{
+		{ExportOptions{Format: "csv"}, []string{"com.a", "10"}, "package,install\ncom.a,10\n"},
+		{ExportOptions{Format: "csv", BOM: true}, []string{"com.a", "10"}, "\ufeffpackage,install\ncom.a,10\n"},
+		{ExportOptions{Format: "csv"}, []string{`a,"b"`, "1"}, "package,install\n\"a,\"\"b\"\"\",1\n"},
+		{ExportOptions{Format: "tsv"}, []string{"a,b", "1"}, "package\tinstall\na,b\t1\n"},
+		// tabs and newlines inside a value are quoted rather than breaking the row
+		{ExportOptions{Format: "tsv"}, []string{"a\tb", "line\nbreak"}, "package\tinstall\n\"a\tb\"\t\"line\nbreak\"\n"},
+		{ExportOptions{Format: "tsv", BOM: true}, []string{"a", "1"}, "\ufeffpackage\tinstall\na\t1\n"},
+	}
+	for _, c := range cases {
+		if got := exportString(t, c.opts, columns, c.row); got != c.want {
+			t.Errorf("%+v %q: expected %q, got %q", c.opts, c.row, c.want, got)
+		}
+	}
+}
+
+func TestJSONValue(t *testing.T) {
+	cases := []struct {
+		column string
+		value  string
+		want   string
+	}{
+		{"install", "100", `100`},
+		{"rr_d1", "0.4100", `0.4100`},
+		{"revenue_d7", "-1.5e3", `-1.5e3`},
+		{"install", "", `null`},
+		{"install", "007", `"007"`},
+		{"install", "NaN", `"NaN"`},
+		{"install", "+5", `"+5"`},
+		{"date", "2025-07-04", `"2025-07-04"`},
+		{"package", "12345", `"12345"`},
+		{"offer_uuid", "1e5", `"1e5"`},
+	}
+	for _, c := range cases {
+		if got := string(jsonValue(c.column, c.value)); got != c.want {
+			t.Errorf("%s=%q: expected %s, got %s", c.column, c.value, c.want, got)
+		}
+	}
+
+	out := exportString(t, ExportOptions{Format: "jsonl"}, []string{"date", "install", "package"}, []string{"2025-07-04", "3", `say "hi"`}, []string{"2025-07-05", "", "com.b"})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || lines[0] != `{"date":"2025-07-04","install":3,"package":"say \"hi\""}` {
+		t.Fatalf("Unexpected JSONL:\n%s", out)
+	}
+	var row map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &row); err != nil || row["install"] != nil || row["package"] != "com.b" {
+		t.Errorf("Unexpected second row %s (%v)", lines[1], err)
+	}
+}
+
+func TestHTMLExporter(t *testing.T) {
+	out := exportString(t, ExportOptions{Format: "html", Title: "A & B"}, []string{"package", "<install>"}, []string{"<script>alert(1)</script>", "12"})
+	if strings.Contains(out, "<script>") || !strings.Contains(out, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Error("Expected cell values to be escaped")
+	}
+	if !strings.Contains(out, "<th>&lt;install&gt;</th>") || !strings.Contains(out, "<title>A &amp; B</title>") {
+		t.Error("Expected column names and the title to be escaped")
+	}
+	if !strings.Contains(out, `<td class="num">12</td>`) || !strings.Contains(out, "<p class=\"meta\">1 rows</p>") {
+		t.Errorf("Unexpected HTML body:\n%s", out)
+	}
+}
+
+func TestNewExporter_UnknownFormat(t *testing.T) {
+	if _, err := newExporter(&bytes.Buffer{}, ExportOptions{Format: "xml"}); err == nil || !strings.Contains(err.Error(), "csv, html, jsonl, tsv") {
+		t.Errorf("Expected an error listing the formats, got %v", err)
+	}
+}
+
+func TestExpandPattern(t *testing.T) {
+	vars := map[string]string{"start": "2025-07-01", "end": "2025-07-07", "ext": "csv"}
+	cases := []struct {
+		pattern string
+		want    string
+		err     bool
+	}{
+		{"report_{start}_{end}.{ext}", "report_2025-07-01_2025-07-07.csv", false},
+		{"{start}/{start}.{ext}", "2025-07-01/2025-07-01.csv", false},
+		{"static.csv", "static.csv", false},
+		// not a placeholder: upper case and empty braces are left alone
+		{"{START}_{}.{ext}", "{START}_{}.csv", false},
+		{"{start}_{account}.{ext}", "", true},
+	}
+	for _, c := range cases {
+		got, err := expandPattern(c.pattern, vars)
+		if (err != nil) != c.err || got != c.want {
+			t.Errorf("%s: expected %q (error %v), got %q, %v", c.pattern, c.want, c.err, got, err)
+		}
+	}
+	if _, err := expandPattern("{a}{b}", nil); err == nil || !strings.Contains(err.Error(), "{a}, {b}") {
+		t.Errorf("Expected every unknown placeholder named, got %v", err)
+	}
+}
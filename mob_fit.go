@@ -0,0 +1,415 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retention models for -model.
+const (
+	modelPower = "power" // rr(t) = a * t^-b
+	modelExp   = "exp"   // rr(t) = a * e^(-b*t)
+	modelBest  = "best"  // whichever fits the observed days better
+)
+
+const (
+	defaultProjectDays    = "60,90,180"
+	defaultPaybackHorizon = 365
+)
+
+// curveFit is a retention curve fitted by least squares in log space:
+// ln rr = ln a - b*x, with x = ln t for the power model and x = t for the
+// exponential one. Day 0 is left out since rr_d0 is 1 by definition.
+type curveFit struct {
+	Model string
+	A, B  float64
+	R2    float64 // in log space
+	RMSE  float64 // in retention units, over the observed days
+	N     int
+
+	meanX, sxx, s float64 // for confidence bands
+}
+
+func (f *curveFit) x(t float64) float64 {
+	if f.Model == modelPower {
+		return math.Log(t)
+	}
+	return t
+}
+
+// at returns the fitted retention on day t, clamped to [0, 1].
+func (f *curveFit) at(t float64) float64 {
+	return clamp01(f.A * math.Exp(-f.B*f.x(t)))
+}
+
+// band returns the 95% confidence band of the fitted curve at day t. With
+// only two points there is no residual variance and the band collapses.
+func (f *curveFit) band(t float64) (float64, float64) {
+	if f.N < 3 {
+		v := f.at(t)
+		return v, v
+	}
+	dx := f.x(t) - f.meanX
+	half := tQuantile975(f.N-2) * f.s * math.Sqrt(1/float64(f.N)+dx*dx/f.sxx)
+	center := math.Log(f.A) - f.B*f.x(t)
+	return clamp01(math.Exp(center - half)), clamp01(math.Exp(center + half))
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// fitCurve fits model to the retention observed on days. Points with no
+// retention (zero) cannot be log-transformed and are skipped.
+func fitCurve(model string, days []int, rr []float64) (*curveFit, error) {
+	if model == modelBest {
+		power, errP := fitCurve(modelPower, days, rr)
+		exp, errE := fitCurve(modelExp, days, rr)
+		switch {
+		case errP != nil:
+			return exp, errE
+		case errE != nil:
+			return power, nil
+		case exp.R2 > power.R2:
+			return exp, nil
+		}
+		return power, nil
+	}
+
+	f := &curveFit{Model: model}
+	var xs, ys []float64
+	for i, day := range days {
+		if day < 1 || rr[i] <= 0 {
+			continue
+		}
+		xs = append(xs, f.x(float64(day)))
+		ys = append(ys, math.Log(rr[i]))
+	}
+	f.N = len(xs)
+	if f.N < 2 {
+		return nil, fmt.Errorf("need retention on at least two days after day 0, have %d", f.N)
+	}
+
+	var meanY float64
+	for i := range xs {
+		f.meanX += xs[i]
+		meanY += ys[i]
+	}
+	f.meanX /= float64(f.N)
+	meanY /= float64(f.N)
+	var sxy, syy float64
+	for i := range xs {
+		f.sxx += (xs[i] - f.meanX) * (xs[i] - f.meanX)
+		sxy += (xs[i] - f.meanX) * (ys[i] - meanY)
+		syy += (ys[i] - meanY) * (ys[i] - meanY)
+	}
+	if f.sxx == 0 {
+		return nil, fmt.Errorf("retention observed on a single day only")
+	}
+	slope := sxy / f.sxx
+	f.B = -slope
+	f.A = math.Exp(meanY - slope*f.meanX)
+
+	var sse, sseRR float64
+	for i, day := range days {
+		if day < 1 || rr[i] <= 0 {
+			continue
+		}
+		r := math.Log(rr[i]) - (math.Log(f.A) - f.B*f.x(float64(day)))
+		sse += r * r
+		d := rr[i] - f.at(float64(day))
+		sseRR += d * d
+	}
+	f.R2 = 1
+	if syy > 0 {
+		f.R2 = 1 - sse/syy
+	}
+	f.RMSE = math.Sqrt(sseRR / float64(f.N))
+	if f.N > 2 {
+		f.s = math.Sqrt(sse / float64(f.N-2))
+	}
+	return f, nil
+}
+
+// tQuantiles are the two-sided 95% Student t critical values by degrees of
+// freedom; past the table the normal value is close enough.
+var tQuantiles = []float64{0, 12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042}
+
+func tQuantile975(dof int) float64 {
+	if dof >= 1 && dof < len(tQuantiles) {
+		return tQuantiles[dof]
+	}
+	return 1.960
+}
+
+// cohortProjection extends one cohort's revenue past the observed days.
+// Revenue per retained user per day (ARPDAU) is taken as constant and
+// estimated from the observed revenue growth over the fitted curve, so
+// cumulative revenue on day D is
+//
+//	revenue(last) + installs * arpdau * sum(rr(t), t = last+1..D)
+type cohortProjection struct {
+	fit      *curveFit
+	installs float64
+	spend    float64
+	revDays  []int     // observed revenue days, ascending
+	revenue  []float64 // cumulative revenue on revDays
+	arpdau   float64
+}
+
+func newCohortProjection(fit *curveFit, installs, spend float64, revDays []int, revenue []float64) *cohortProjection {
+	p := &cohortProjection{fit: fit, installs: installs, spend: spend, revDays: revDays, revenue: revenue}
+	if n := len(revDays); n >= 2 && installs > 0 {
+		var retained float64
+		for t := revDays[0] + 1; t <= revDays[n-1]; t++ {
+			retained += fit.at(float64(t))
+		}
+		if retained > 0 {
+			p.arpdau = (revenue[n-1] - revenue[0]) / (installs * retained)
+		}
+	}
+	return p
+}
+
+// cumulative returns revenue up to day t, interpolating between observed
+// days and projecting with curve past the last one.
+func (p *cohortProjection) cumulative(t int, curve func(float64) float64) (float64, bool) {
+	n := len(p.revDays)
+	if n == 0 || t < p.revDays[0] {
+		return 0, false
+	}
+	for i := 1; i < n; i++ {
+		if t <= p.revDays[i] {
+			lo, hi := p.revDays[i-1], p.revDays[i]
+			frac := float64(t-lo) / float64(hi-lo)
+			return p.revenue[i-1] + frac*(p.revenue[i]-p.revenue[i-1]), true
+		}
+	}
+	if t == p.revDays[n-1] {
+		return p.revenue[n-1], true
+	}
+	if p.arpdau == 0 && n < 2 {
+		return 0, false
+	}
+	total := p.revenue[n-1]
+	for d := p.revDays[n-1] + 1; d <= t; d++ {
+		total += p.installs * p.arpdau * curve(float64(d))
+	}
+	return total, true
+}
+
+// paybackDay is the first day cumulative revenue covers spend, or -1 if
+// that does not happen within horizon days.
+func (p *cohortProjection) paybackDay(horizon int) int {
+	if p.spend <= 0 {
+		return -1
+	}
+	for t := 0; t <= horizon; t++ {
+		if rev, ok := p.cumulative(t, p.fit.at); ok && rev >= p.spend {
+			return t
+		}
+	}
+	return -1
+}
+
+// projectCohort fits one group and returns its output row. Observed
+// revenue is taken per install and scaled to the group's installs, since
+// with maturity applied each day covers a different set of rows.
+func projectCohort(g *reportGroup, days []int, model string, targets []int, horizon, decimals int) ([]string, error) {
+	installs := g.installs()
+	var rrDays []int
+	var rr []float64
+	var revDays []int
+	var revenue []float64
+	for _, day := range days {
+		if v, ok := g.retention(day); ok {
+			rrDays = append(rrDays, day)
+			rr = append(rr, v)
+		}
+		if v, ok := g.revenuePerInstall(day); ok {
+			revDays = append(revDays, day)
+			revenue = append(revenue, v*installs)
+		}
+	}
+	fit, err := fitCurve(model, rrDays, rr)
+	if err != nil {
+		return nil, err
+	}
+
+	num := func(v float64) string { return strconv.FormatFloat(v, 'f', decimals, 64) }
+	proj := newCohortProjection(fit, installs, g.spendTotal(), revDays, revenue)
+	row := append(append([]string(nil), g.dims...),
+		strconv.FormatFloat(installs, 'f', -1, 64),
+		num(proj.spend),
+		fit.Model, num(fit.A), num(fit.B), num(fit.R2), num(fit.RMSE), strconv.Itoa(fit.N),
+	)
+	for _, t := range targets {
+		lo, hi := fit.band(float64(t))
+		row = append(row, num(fit.at(float64(t))), num(lo), num(hi))
+	}
+	lower := func(t float64) float64 { lo, _ := fit.band(t); return lo }
+	upper := func(t float64) float64 { _, hi := fit.band(t); return hi }
+	for _, t := range targets {
+		rev, ok := proj.cumulative(t, fit.at)
+		if !ok || installs == 0 {
+			row = append(row, "", "", "", "")
+			continue
+		}
+		revLo, _ := proj.cumulative(t, lower)
+		revHi, _ := proj.cumulative(t, upper)
+		roas := ""
+		if proj.spend > 0 {
+			roas = num(rev / proj.spend)
+		}
+		row = append(row, num(rev/installs), num(revLo/installs), num(revHi/installs), roas)
+	}
+	if day := proj.paybackDay(horizon); day >= 0 {
+		row = append(row, strconv.Itoa(day))
+	} else {
+		row = append(row, "")
+	}
+	return row, nil
+}
+
+func projectColumns(by []string, targets []int) []string {
+	columns := append(append([]string(nil), by...), "install", "spend", "model", "fit_a", "fit_b", "fit_r2", "fit_rmse", "fit_points")
+	for _, t := range targets {
+		columns = append(columns, fmt.Sprintf("rr_d%d", t), fmt.Sprintf("rr_d%d_low", t), fmt.Sprintf("rr_d%d_high", t))
+	}
+	for _, t := range targets {
+		columns = append(columns, fmt.Sprintf("ltv_d%d", t), fmt.Sprintf("ltv_d%d_low", t), fmt.Sprintf("ltv_d%d_high", t), fmt.Sprintf("roas_d%d", t))
+	}
+	return append(columns, "payback_day")
+}
+
+func parseDays(s string) ([]int, error) {
+	var days []int
+	for _, part := range splitList(s) {
+		day, err := strconv.Atoi(part)
+		if err != nil || day < 1 {
+			return nil, fmt.Errorf("invalid day %q", part)
+		}
+		days = append(days, day)
+	}
+	if len(days) == 0 {
+		return nil, fmt.Errorf("no days in %q", s)
+	}
+	sort.Ints(days)
+	return days, nil
+}
+
+// runProject fits retention per cohort and projects retention, LTV and
+// payback from exported rows.
+func runProject(args []string) {
+	fs := flag.NewFlagSet("project", flag.ExitOnError)
+	by := fs.String("by", "offer_id", "comma-separated columns that define a cohort; week and month are derived from date")
+	model := fs.String("model", modelBest, "retention model: power, exp or best")
+	targetsFlag := fs.String("days", defaultProjectDays, "days to project retention, LTV and ROAS for")
+	horizon := fs.Int("horizon", defaultPaybackHorizon, "last day searched for ROAS payback")
+	decimals := fs.Int("decimals", defaultReportDecimals, "decimal places in the output")
+	maturityFlags := registerAsOfFlags(fs)
+	tz := fs.String("tz", defaultTimezone, "timezone the report dates are in, e.g. UTC or Asia/Shanghai")
+	output := fs.String("o", "", "write the projection to this file instead of stdout")
+	exportOpts := ExportOptions{}
+	fs.StringVar(&exportOpts.Format, "format", "csv", "output format: "+formatNames())
+	fs.BoolVar(&exportOpts.BOM, "bom", false, "start CSV/TSV output with a UTF-8 BOM")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s project [flags] EXPORT...\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if _, ok := outputFormats[exportOpts.Format]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q (want one of %s)\n", exportOpts.Format, formatNames())
+		os.Exit(2)
+	}
+	if *model != modelPower && *model != modelExp && *model != modelBest {
+		fmt.Fprintf(os.Stderr, "unknown model %q (want power, exp or best)\n", *model)
+		os.Exit(2)
+	}
+	targets, err := parseDays(*targetsFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "days:", err)
+		os.Exit(2)
+	}
+	dims := splitList(*by)
+	if fs.NArg() == 0 || len(dims) == 0 || *horizon < 1 || *decimals < 0 || maturityFlags.lag < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid timezone %q: %v\n", *tz, err)
+		os.Exit(2)
+	}
+	// day-N metrics only count install dates that are complete for day N
+	mature, err := maturityFlags.at(loc, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	builder := newReportBuilder(reportOptions{By: dims, Maturity: mature})
+	for _, path := range fs.Args() {
+		header, records, err := readExportFile(path)
+		if err == nil {
+			err = builder.add(header, records)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(1)
+		}
+	}
+
+	days := builder.sortedDays()
+	var rows [][]string
+	skipped := 0
+	for _, g := range builder.sortedGroups() {
+		row, err := projectCohort(g, days, *model, targets, *horizon, *decimals)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", strings.Join(g.dims, "/"), err)
+			skipped++
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	exportOpts.Title = "Mobvista IAA projection by " + strings.Join(dims, ", ")
+	write := func(w io.Writer) error {
+		exporter, err := newExporter(w, exportOpts)
+		if err != nil {
+			return err
+		}
+		if err := exporter.Begin(projectColumns(builder.opts.By, targets)); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := exporter.WriteRow(row); err != nil {
+				return err
+			}
+		}
+		return exporter.End()
+	}
+	if *output == "" {
+		err = write(os.Stdout)
+	} else {
+		err = writeFileAtomic(*output, write)
+		if err == nil {
+			fmt.Printf("%d cohorts projected to %s (%d skipped)\n", len(rows), *output, skipped)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "writing projection failed:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+var fitDays = []int{0, 1, 3, 7, 14, 30}
+
+func curve(days []int, f func(t float64) float64) []float64 {
+	rr := make([]float64, len(days))
+	for i, day := range days {
+		rr[i] = f(float64(day))
+	}
+	rr[0] = 1
+	return rr
+}
+
+func TestFitCurve_Power(t *testing.T) {
+	rr := curve(fitDays, func(t float64) float64 { return 0.4 * math.Pow(t, -0.5) })
+	fit, err := fitCurve(modelPower, fitDays, rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(fit.A-0.4) > 1e-9 || math.Abs(fit.B-0.5) > 1e-9 || fit.R2 < 0.999999 || fit.N != 5 {
+		t.Errorf("Expected a=0.4 b=0.5 r2=1 n=5, got %+v", fit)
+	}
+	if got := fit.at(100); math.Abs(got-0.04) > 1e-9 {
+		t.Errorf("Expected rr_d100 0.04, got %v", got)
+	}
+	// an exact fit has no residuals, so the band is a line
+	if lo, hi := fit.band(90); math.Abs(hi-lo) > 1e-9 {
+		t.Errorf("Expected a collapsed band, got %v..%v", lo, hi)
+	}
+}
+
+func TestFitCurve_Best(t *testing.T) {
+	rr := curve(fitDays, func(t float64) float64 { return 0.5 * math.Exp(-0.1*t) })
+	fit, err := fitCurve(modelBest, fitDays, rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fit.Model != modelExp || math.Abs(fit.B-0.1) > 1e-9 {
+		t.Errorf("Expected exp with b=0.1, got %+v", fit)
+	}
+
+	// noise widens the band around the fitted value
+	rr = []float64{1, 0.42, 0.25, 0.14, 0.11, 0.07}
+	fit, err = fitCurve(modelPower, fitDays, rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lo, hi := fit.band(90)
+	if mid := fit.at(90); !(lo < mid && mid < hi) || fit.RMSE == 0 {
+		t.Errorf("Expected %v inside %v..%v with rmse > 0, got rmse %v", mid, lo, hi, fit.RMSE)
+	}
+}
+
+func TestFitCurve_TooFewPoints(t *testing.T) {
+	if _, err := fitCurve(modelPower, []int{0, 1, 7}, []float64{1, 0.3, 0}); err == nil {
+		t.Error("Expected a single usable day to be rejected")
+	}
+}
+
+func TestCohortProjection_Payback(t *testing.T) {
+	fit := &curveFit{Model: modelPower, A: 1, B: 0}
+	// flat retention of 1 and 1.0 per user per day after day 7
+	p := newCohortProjection(fit, 10, 150, []int{0, 7}, []float64{10, 80})
+	if p.arpdau != 1 {
+		t.Fatalf("Expected arpdau 1, got %v", p.arpdau)
+	}
+	if rev, _ := p.cumulative(3, fit.at); rev != 40 {
+		t.Errorf("Expected interpolated revenue 40 on day 3, got %v", rev)
+	}
+	if rev, _ := p.cumulative(10, fit.at); rev != 110 {
+		t.Errorf("Expected projected revenue 110 on day 10, got %v", rev)
+	}
+	if day := p.paybackDay(365); day != 14 {
+		t.Errorf("Expected payback on day 14, got %d", day)
+	}
+	if day := p.paybackDay(10); day != -1 {
+		t.Errorf("Expected no payback within 10 days, got %d", day)
+	}
+}
+
+func TestProject_IgnoresImmatureDays(t *testing.T) {
+	header := []string{"date", "offer_id", "install", "rr_d1", "rr_d7", "rr_d14", "rr_d30"}
+	mature := [][]string{
+		{"2025-05-01", "10", "100", "0.4", "0.2", "0.15", "0.1"},
+		{"2025-05-02", "10", "100", "0.42", "0.21", "0.16", "0.11"},
+	}
+	// ten days old: d1 and d7 match the mature mean, d14 and d30 are still
+	// filling in
+	young := []string{"2025-06-21", "10", "1000", "0.41", "0.205", "0.02", "0.01"}
+	m := &maturity{AsOf: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC), Location: time.UTC}
+
+	fitColumns := func(records [][]string, m *maturity) string {
+		b := newReportBuilder(reportOptions{By: []string{"offer_id"}, Maturity: m})
+		if err := b.add(header, records); err != nil {
+			t.Fatal(err)
+		}
+		row, err := projectCohort(b.sortedGroups()[0], b.sortedDays(), modelPower, []int{60}, 365, 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(row[4:8], ",") // fit_a, fit_b, fit_r2, fit_rmse
+	}
+	want := fitColumns(mature, m)
+	if got := fitColumns(append(mature, young), m); got != want {
+		t.Errorf("Expected the immature date not to change the fit %s, got %s", want, got)
+	}
+	if got := fitColumns(append(mature, young), nil); got == want {
+		t.Error("Expected the partial values to change the fit without maturity")
+	}
+}
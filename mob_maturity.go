@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cohort maturity. rr_dN, dN_roas and revenue_dN for an install date only
+// stop changing once day N of that cohort is over. A row exported before
+// then carries partial numbers, which -immature can mark or blank.
+const (
+	immatureKeep  = "keep"
+	immatureMark  = "mark"
+	immatureBlank = "blank"
+
+	// matureSuffix names the true/false column -immature mark adds after
+	// each day-N column, so the metric itself stays a plain number.
+	matureSuffix = "_mature"
+)
+
+// maturityColumns are added to each row with -maturity-columns.
+var maturityColumns = []string{"cohort_age_days", "mature_through_day", "fully_mature"}
+
+var cohortMetric = regexp.MustCompile(`^(?:rr_d|revenue_d)([0-9]+)$|^d([0-9]+)_roas$`)
+
+// cohortDay returns N for a day-N metric name, or -1.
+func cohortDay(name string) int {
+	m := cohortMetric.FindStringSubmatch(name)
+	if m == nil {
+		return -1
+	}
+	day, err := strconv.Atoi(m[1] + m[2])
+	if err != nil {
+		return -1
+	}
+	return day
+}
+
+// maturity decides which cohort days of a row are complete as of a date.
+type maturity struct {
+	Policy   string
+	AsOf     time.Time // export date in the report timezone
+	Lag      int       // extra days the network needs to settle a day
+	Columns  bool      // add maturityColumns
+	Location *time.Location
+}
+
+// age is the number of whole days from the row's install date to AsOf.
+func (m *maturity) age(row *IAARow) (int, bool) {
+	return m.ageOf(string(row.Date))
+}
+
+// ageOf is age for a YYYY-MM-DD install date read from an export.
+func (m *maturity) ageOf(day string) (int, bool) {
+	date, err := parseDate(day, m.Location)
+	if err != nil {
+		return 0, false
+	}
+	return daysBetween(date, m.AsOf), true
+}
+
+// mature reports whether day of a cohort aged age is complete: the day has
+// ended and Lag more days have passed.
+func (m *maturity) mature(age, day int) bool {
+	return age > day+m.Lag
+}
+
+// apply blanks the day-N columns or adds a <col>_mature column after each,
+// according to Policy, and appends the metadata columns if asked. A nil
+// maturity leaves columns as they are.
+func (m *maturity) apply(columns []Column) []Column {
+	if m == nil {
+		return columns
+	}
+	maxDay := -1
+	out := make([]Column, 0, len(columns)+len(maturityColumns))
+	for _, column := range columns {
+		maxDay = max(maxDay, column.Day)
+		if column.Day < 0 || m.Policy == immatureKeep {
+			out = append(out, column)
+			continue
+		}
+		value, day := column.value, column.Day
+		if m.Policy == immatureMark {
+			out = append(out, column, Column{Name: column.Name + matureSuffix, Day: -1, value: func(row *IAARow, opts FormatOptions) string {
+				age, ok := m.age(row)
+				if !ok || value(row, opts) == "" {
+					return ""
+				}
+				return strconv.FormatBool(m.mature(age, day))
+			}})
+			continue
+		}
+		column.value = func(row *IAARow, opts FormatOptions) string {
+			v := value(row, opts)
+			age, ok := m.age(row)
+			if v == "" || !ok || m.mature(age, day) {
+				return v
+			}
+			return ""
+		}
+		out = append(out, column)
+	}
+	if !m.Columns {
+		return out
+	}
+
+	meta := func(name string, value func(age int) string) Column {
+		return Column{Name: name, Day: -1, value: func(row *IAARow, _ FormatOptions) string {
+			age, ok := m.age(row)
+			if !ok {
+				return ""
+			}
+			return value(age)
+		}}
+	}
+	return append(out,
+		meta(maturityColumns[0], strconv.Itoa),
+		meta(maturityColumns[1], func(age int) string {
+			if through := age - 1 - m.Lag; through >= 0 {
+				return strconv.Itoa(through)
+			}
+			return ""
+		}),
+		meta(maturityColumns[2], func(age int) string {
+			return strconv.FormatBool(m.mature(age, maxDay))
+		}),
+	)
+}
+
+// maturityFlags are the -immature, -maturity-* and -as-of options.
+type maturityFlags struct {
+	policy  string
+	lag     int
+	columns bool
+	asOf    string
+}
+
+func registerMaturityFlags(fs *flag.FlagSet) *maturityFlags {
+	f := registerAsOfFlags(fs)
+	fs.StringVar(&f.policy, "immature", immatureKeep, "day-N metrics of cohorts too young to be complete: keep, mark (add a <col>"+matureSuffix+" column) or blank")
+	fs.BoolVar(&f.columns, "maturity-columns", false, "add "+strings.Join(maturityColumns, ", ")+" columns to every row")
+	return f
+}
+
+// registerAsOfFlags registers only -as-of and -maturity-lag, for commands
+// that always judge maturity and so have no -immature policy.
+func registerAsOfFlags(fs *flag.FlagSet) *maturityFlags {
+	f := &maturityFlags{}
+	fs.IntVar(&f.lag, "maturity-lag", 0, "extra days after day N ends before its numbers count as final")
+	fs.StringVar(&f.asOf, "as-of", "", "date maturity is judged at, YYYY-MM-DD (default today in -tz)")
+	return f
+}
+
+// resolve returns nil when maturity handling is off, so apply is a no-op.
+func (f *maturityFlags) resolve(loc *time.Location, now time.Time) (*maturity, error) {
+	switch f.policy {
+	case immatureKeep, immatureMark, immatureBlank:
+	default:
+		return nil, fmt.Errorf("unknown -immature %q (want keep, mark or blank)", f.policy)
+	}
+	if f.lag < 0 {
+		return nil, fmt.Errorf("maturity-lag must not be negative")
+	}
+	if f.policy == immatureKeep && !f.columns {
+		return nil, nil
+	}
+	return f.at(loc, now)
+}
+
+// at returns the maturity as of -as-of, or today in loc, whatever the policy.
+func (f *maturityFlags) at(loc *time.Location, now time.Time) (*maturity, error) {
+	asOf := now.In(loc)
+	if f.asOf != "" {
+		var err error
+		if asOf, err = parseDate(f.asOf, loc); err != nil {
+			return nil, fmt.Errorf("as-of: %v", err)
+		}
+	}
+	return &maturity{Policy: f.policy, AsOf: asOf, Lag: f.lag, Columns: f.columns, Location: loc}, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCohortDay(t *testing.T) {
+	cases := map[string]int{"rr_d7": 7, "d30_roas": 30, "revenue_d0": 0, "rr_d60": 60, "install": -1, "d7_roas_pct": -1}
+	for name, want := range cases {
+		if got := cohortDay(name); got != want {
+			t.Errorf("%s: expected %d, got %d", name, want, got)
+		}
+	}
+}
+
+func TestMaturity(t *testing.T) {
+	asOf := time.Date(2025, 7, 11, 15, 0, 0, 0, time.UTC)
+	row := decodeRow(t, `{"date":"2025-07-04","install":100,"rr_d3":0.3,"rr_d7":0.2,"d7_roas":0.5,"revenue_d14":""}`)
+	base := fieldColumns([]string{"date", "install", "rr_d3", "rr_d7", "d7_roas", "revenue_d14"})
+
+	cases := []struct {
+		policy string
+		lag    int
+		want   string
+	}{
+		// 7 days old: day 3 is over, day 7 is still running
+		{immatureKeep, 0, "2025-07-04,100,0.3,0.2,0.5,,7,6,false"},
+		{immatureMark, 0, "2025-07-04,100,0.3,true,0.2,false,0.5,false,,,7,6,false"},
+		{immatureBlank, 0, "2025-07-04,100,0.3,,,,7,6,false"},
+		{immatureBlank, 4, "2025-07-04,100,,,,,7,2,false"},
+	}
+	for _, c := range cases {
+		m := &maturity{Policy: c.policy, AsOf: asOf, Lag: c.lag, Columns: true, Location: time.UTC}
+		columns := m.apply(base)
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = column.Value(row, defaultFormatOptions)
+		}
+		if got := strings.Join(values, ","); got != c.want {
+			t.Errorf("%s lag %d: expected %s, got %s", c.policy, c.lag, c.want, got)
+		}
+	}
+}
+
+func TestMaturity_DerivedColumnUsesLatestDay(t *testing.T) {
+	column, err := parseColumnLine("growth = revenue_d7 / revenue_d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if column.Day != 7 {
+		t.Errorf("Expected derived column to depend on day 7, got %d", column.Day)
+	}
+}
+
+func TestMaturityFlags_Off(t *testing.T) {
+	f := &maturityFlags{policy: immatureKeep}
+	m, err := f.resolve(time.UTC, time.Now())
+	if err != nil || m != nil {
+		t.Errorf("Expected maturity handling off by default, got %+v, %v", m, err)
+	}
+	f.policy = "hide"
+	if _, err := f.resolve(time.UTC, time.Now()); err == nil {
+		t.Error("Expected unknown -immature policy to be rejected")
+	}
+}
+
+func TestMaturityFlags_At(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 2025-07-07 20:00 UTC is already 2025-07-08 in Shanghai
+	now := time.Date(2025, 7, 7, 20, 0, 0, 0, time.UTC)
+	f := &maturityFlags{}
+	m, err := f.at(shanghai, now)
+	if err != nil || m.Location != shanghai || m.AsOf.Format("2006-01-02") != "2025-07-08" {
+		t.Errorf("Expected today taken in the report timezone, got %+v, %v", m, err)
+	}
+	if age, _ := m.ageOf("2025-07-07"); age != 1 {
+		t.Errorf("Expected the 2025-07-07 cohort 1 day old, got %d", age)
+	}
+	f.asOf = "2025-07-01"
+	if m, err = f.at(shanghai, now); err != nil || !m.AsOf.Equal(time.Date(2025, 7, 1, 0, 0, 0, 0, shanghai)) {
+		t.Errorf("Expected -as-of parsed in the report timezone, got %+v, %v", m, err)
+	}
+}
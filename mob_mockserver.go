@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	mockPath          = "/channel/iaa/v1"
+	defaultMockAddr   = "127.0.0.1:8080"
+	defaultMockOffers = 20
+	defaultMockSkew   = 5 * time.Minute
+)
+
+// mockFaults are injected per request with the given probabilities.
+type mockFaults struct {
+	BadToken    float64       // answer as if the token did not verify
+	RateLimit   float64       // 429 with a Retry-After header
+	ServerError float64       // 500
+	Malformed   float64       // 200 with a truncated JSON body
+	SlowRate    float64       // delay the response by Slow
+	Slow        time.Duration // how long slow responses are held
+	RetryAfter  int           // seconds sent with 429 responses
+}
+
+// mockServer is a local stand-in for the /channel/iaa/v1 endpoint. It checks
+// the token the same way the real API does and serves deterministic
+// synthetic rows, so the same request always returns the same data.
+type mockServer struct {
+	clientKey string
+	verifier  *Verifier
+	offers    int
+	faults    mockFaults
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newMockServer(clientKey, secret string, offers int, faults mockFaults, seed int64) *mockServer {
+	return &mockServer{
+		clientKey: clientKey,
+		verifier:  &Verifier{Secret: secret, MaxSkew: defaultMockSkew},
+		offers:    offers,
+		faults:    faults,
+		rand:      rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (s *mockServer) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rand.Float64() < p
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != mockPath {
+		http.NotFound(w, r)
+		return
+	}
+	if s.chance(s.faults.SlowRate) {
+		select {
+		case <-time.After(s.faults.Slow):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	switch {
+	case s.chance(s.faults.RateLimit):
+		w.Header().Set("Retry-After", strconv.Itoa(s.faults.RetryAfter))
+		writeMockJSON(w, http.StatusTooManyRequests, map[string]interface{}{"status": false, "msg": "too many requests"})
+		return
+	case s.chance(s.faults.ServerError):
+		writeMockJSON(w, http.StatusInternalServerError, map[string]interface{}{"status": false, "msg": "internal error"})
+		return
+	}
+
+	q := r.URL.Query()
+	if msg := s.verify(q); msg != "" || s.chance(s.faults.BadToken) {
+		writeMockJSON(w, http.StatusOK, map[string]interface{}{"status": false, "msg": firstNonEmpty(msg, "invalid token")})
+		return
+	}
+
+	window, err := mockWindow(q.Get("start_date"), q.Get("end_date"))
+	if err != nil {
+		writeMockJSON(w, http.StatusOK, map[string]interface{}{"status": false, "msg": err.Error()})
+		return
+	}
+	page, _ := strconv.Atoi(q.Get("page"))
+	perPage, _ := strconv.Atoi(q.Get("per_page"))
+	if page < 1 || perPage < 1 {
+		writeMockJSON(w, http.StatusOK, map[string]interface{}{"status": false, "msg": "invalid page or per_page"})
+		return
+	}
+
+	total := window.Days() * s.offers
+	rows := make([]map[string]interface{}, 0, perPage)
+	for i := (page - 1) * perPage; i < total && len(rows) < perPage; i++ {
+		date := window.Start.AddDate(0, 0, i/s.offers).Format(dateLayout)
+		rows = append(rows, mockRow(date, i%s.offers))
+	}
+
+	if s.chance(s.faults.Malformed) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"status":true,"data":{"total":`)
+		return
+	}
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"status": true,
+		"data":   map[string]interface{}{"total": total, "data": rows},
+	})
+}
+
+// verify checks the signature with the shared Verifier and returns a
+// message describing why the request is rejected, if it is.
+func (s *mockServer) verify(q url.Values) string {
+	if q.Get("client_key") != s.clientKey {
+		return "unknown client_key"
+	}
+	if err := s.verifier.Verify(q); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func mockWindow(start, end string) (DateRange, error) {
+	s, err := parseDate(start, time.UTC)
+	if err != nil {
+		return DateRange{}, fmt.Errorf("start_date: %v", err)
+	}
+	e, err := parseDate(end, time.UTC)
+	if err != nil {
+		return DateRange{}, fmt.Errorf("end_date: %v", err)
+	}
+	if e.Before(s) {
+		return DateRange{}, fmt.Errorf("end_date before start_date")
+	}
+	return DateRange{Start: s, End: e, Location: time.UTC}, nil
+}
+
+// mockRow builds a plausible row: retention decays with age, revenue is
+// cumulative and ROAS is revenue over a per-offer spend. Values are seeded
+// from the date and offer so they are stable across requests.
+func mockRow(date string, offer int) map[string]interface{} {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%d", date, offer)
+	rnd := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	install := 200 + rnd.Intn(4800)
+	cpi := 0.3 + rnd.Float64()*1.2
+	spend := float64(install) * cpi
+	arpuD0 := 0.05 + rnd.Float64()*0.2
+
+	row := map[string]interface{}{
+		"date":         date,
+		"channel_id":   100000 + offer%5,
+		"channel_uuid": fmt.Sprintf("ch-%04d", offer%5),
+		"offer_id":     5000000 + offer,
+		"offer_uuid":   fmt.Sprintf("of-%06d", offer),
+		"package":      fmt.Sprintf("com.example.game%d", offer%7),
+		"install":      install,
+		"impressions":  install * (20 + rnd.Intn(40)),
+	}
+	for _, day := range []int{0, 1, 3, 7, 14, 30} {
+		rr := 1.0
+		if day > 0 {
+			rr = 0.45 * math.Pow(float64(day), -0.5) * (0.9 + rnd.Float64()*0.2)
+		}
+		revenue := float64(install) * arpuD0 * (1 + 1.6*math.Log1p(float64(day)))
+		row[fmt.Sprintf("rr_d%d", day)] = round(rr, 4)
+		row[fmt.Sprintf("revenue_d%d", day)] = round(revenue, 2)
+		row[fmt.Sprintf("d%d_roas", day)] = round(revenue/spend, 4)
+	}
+	return row
+}
+
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// runMock serves the stand-in API until interrupted.
+func runMock(args []string) {
+	fs := flag.NewFlagSet("mock", flag.ExitOnError)
+	addr := fs.String("addr", defaultMockAddr, "listen address")
+	clientKey := fs.String("client-key", "10001", "client key the mock accepts")
+	secretRef := fs.String("secret-ref", "", "secret the mock verifies tokens with: env:VAR or file:PATH (default $MOB_CLIENT_SECRET)")
+	offers := fs.Int("offers", defaultMockOffers, "synthetic offers per date")
+	seed := fs.Int64("seed", 1, "seed for fault injection")
+	maxSkew := fs.Duration("max-skew", defaultMockSkew, "how far the request time may be from the server clock (0 disables)")
+	rejectReplay := fs.Bool("reject-replay", false, "reject a token that has already been used")
+	var faults mockFaults
+	fs.Float64Var(&faults.BadToken, "fault-bad-token", 0, "probability of rejecting a valid token")
+	fs.Float64Var(&faults.RateLimit, "fault-429", 0, "probability of a 429 response")
+	fs.Float64Var(&faults.ServerError, "fault-500", 0, "probability of a 500 response")
+	fs.Float64Var(&faults.Malformed, "fault-malformed", 0, "probability of a truncated JSON body")
+	fs.Float64Var(&faults.SlowRate, "fault-slow", 0, "probability of a slow response")
+	fs.DurationVar(&faults.Slow, "slow", 5*time.Second, "delay for slow responses")
+	fs.IntVar(&faults.RetryAfter, "retry-after", 1, "Retry-After seconds on 429 responses")
+	fs.Parse(args)
+
+	secret := os.Getenv("MOB_CLIENT_SECRET")
+	if *secretRef != "" {
+		var err error
+		if secret, err = resolveSecret(*secretRef); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if secret == "" {
+		fmt.Fprintln(os.Stderr, "mock needs a secret: -secret-ref or MOB_CLIENT_SECRET")
+		os.Exit(2)
+	}
+	if *offers < 1 {
+		fmt.Fprintln(os.Stderr, "offers must be positive")
+		os.Exit(2)
+	}
+
+	server := newMockServer(*clientKey, secret, *offers, faults, *seed)
+	server.verifier.MaxSkew = *maxSkew
+	if *rejectReplay {
+		server.verifier.Replay = newMemoryReplayGuard(2**maxSkew + time.Minute)
+	}
+	fmt.Printf("Mock IAA API on http://%s%s (client_key=%s)\n", *addr, mockPath, *clientKey)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newMockClient(t *testing.T, faults mockFaults, secret string) (*apiClient, DateRange) {
+	server := httptest.NewServer(newMockServer("10001", "mock-secret", 7, faults, 1))
+	t.Cleanup(server.Close)
+
+	cfg := &Config{
+		BaseURL:     server.URL + mockPath,
+		ClientKey:   "10001",
+		PerPage:     10,
+		Concurrency: 3,
+		Timeout:     2 * time.Second,
+		MaxRetries:  2,
+		secret:      secret,
+	}
+	client := newAPIClient(cfg)
+	client.baseDelay = time.Millisecond
+	client.maxDelay = 5 * time.Millisecond
+
+	window, err := resolveDateRange("2025-07-04", "2025-07-11", defaultDays, false, "UTC", time.Date(2025, 7, 20, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, window
+}
+
+func TestMockServer_AllPages(t *testing.T) {
+	client, window := newMockClient(t, mockFaults{}, "mock-secret")
+
+	rows, err := fetchAllPages(context.Background(), client, window)
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	// 8 days x 7 offers, spread over 6 pages of 10
+	if len(rows) != 56 {
+		t.Fatalf("Expected 56 rows, got %d", len(rows))
+	}
+	if rows[0].Value("date", defaultFormatOptions) != "2025-07-04" || rows[55].Value("date", defaultFormatOptions) != "2025-07-11" {
+		t.Errorf("rows out of order: first %s, last %s", rows[0].Date, rows[55].Date)
+	}
+}
+
+func TestMockServer_Faults(t *testing.T) {
+	cases := []struct {
+		name   string
+		faults mockFaults
+		secret string
+		want   error
+	}{
+		{"wrong secret", mockFaults{}, "not-the-secret", ErrAuth},
+		{"bad token", mockFaults{BadToken: 1}, "mock-secret", ErrAuth},
+		{"rate limited", mockFaults{RateLimit: 1}, "mock-secret", ErrRateLimited},
+		{"server error", mockFaults{ServerError: 1}, "mock-secret", ErrServer},
+		{"malformed", mockFaults{Malformed: 1}, "mock-secret", ErrDecode},
+	}
+	for _, c := range cases {
+		client, window := newMockClient(t, c.faults, c.secret)
+		_, err := fetchAllPages(context.Background(), client, window)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, err)
+		}
+	}
+}
+
+func TestMockServer_SlowResponseTimesOut(t *testing.T) {
+	client, window := newMockClient(t, mockFaults{SlowRate: 1, Slow: time.Second}, "mock-secret")
+	client.httpClient.Timeout = 50 * time.Millisecond
+	client.maxRetries = 0
+
+	if _, err := fetchAllPages(context.Background(), client, window); !errors.Is(err, ErrNetwork) {
+		t.Errorf("Expected network timeout error, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	defaultPerPage     = 300
+	defaultConcurrency = 4
+)
+
+// pageResult is what a worker hands back for one page request.
+type pageResult struct {
+	page  int
+	total int
+	rows  []*IAARow
+	err   error
+}
+
+// fetchPage requests a single page and wraps the outcome for the worker
+// pool. The client signs every attempt, so each page and each retry
+// carries a fresh time and token.
+func fetchPage(ctx context.Context, client *apiClient, window DateRange, page int) pageResult {
+	resp, err := client.fetchPage(ctx, window, page)
+	if err != nil {
+		return pageResult{page: page, err: err}
+	}
+	return pageResult{page: page, total: resp.Data.Total, rows: resp.Data.Data}
+}
+
+// fetchPages fetches the given pages with at most Concurrency requests in
+// flight and returns the results indexed like pages. The first failure
+// cancels the pages still queued or in flight and is returned on its own.
+func fetchPages(ctx context.Context, client *apiClient, window DateRange, pages []int) ([]pageResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pageChan := make(chan int)
+	results := make([]pageResult, len(pages))
+	index := make(map[int]int, len(pages))
+	for i, p := range pages {
+		index[p] = i
+	}
+
+	var wg sync.WaitGroup
+	var failed sync.Once
+	var firstErr error
+	for i := 0; i < client.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range pageChan {
+				result := fetchPage(ctx, client, window, page)
+				if result.err != nil {
+					failed.Do(func() {
+						firstErr = result.err
+						cancel()
+					})
+				}
+				results[index[page]] = result
+			}
+		}()
+	}
+
+feed:
+	for _, p := range pages {
+		select {
+		case pageChan <- p:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(pageChan)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	// the caller's context may have ended before every page was queued
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// fetchAllPages walks every page of the report for window. Page 1 is fetched on its own
+// to learn the reported total; the remaining pages go through a bounded pool
+// of workers. Paging stops at the first empty page or once the reported
+// total has been collected.
+func fetchAllPages(ctx context.Context, client *apiClient, window DateRange) ([]*IAARow, error) {
+	perPage := client.cfg.PerPage
+	first := fetchPage(ctx, client, window, 1)
+	if first.err != nil {
+		return nil, first.err
+	}
+	rows := first.rows
+	total := first.total
+	if len(first.rows) == 0 || (total > 0 && len(rows) >= total) {
+		return rows, nil
+	}
+
+	next := 2
+	for {
+		// With a known total we can queue every remaining page at once,
+		// otherwise probe ahead one batch at a time.
+		batch := client.cfg.Concurrency
+		if total > 0 {
+			batch = (total - len(rows) + perPage - 1) / perPage
+		}
+		pages := make([]int, batch)
+		for i := range pages {
+			pages[i] = next + i
+		}
+		next += batch
+
+		results, err := fetchPages(ctx, client, window, pages)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if len(result.rows) == 0 {
+				return rows, nil
+			}
+			rows = append(rows, result.rows...)
+			if total > 0 && len(rows) >= total {
+				return rows, nil
+			}
+		}
+	}
+}
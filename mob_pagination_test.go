@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pagedClient returns a client for a server that answers each page with
+// the number of rows sizes gives it (0 past the end) and reports total.
+// A negative size answers that page with 401. The returned function lists
+// the pages requested so far.
+func pagedClient(t *testing.T, total int, sizes ...int) (*apiClient, func() []int) {
+	var mu sync.Mutex
+	var requested []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		mu.Lock()
+		requested = append(requested, page)
+		mu.Unlock()
+		size := 0
+		if page <= len(sizes) {
+			size = sizes[page-1]
+		}
+		if size < 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"status":false,"msg":"bad token"}`)
+			return
+		}
+		if page > 2 {
+			// later pages are slow, so a failing page 2 is seen first
+			time.Sleep(20 * time.Millisecond)
+		}
+		rows := make([]string, size)
+		for i := range rows {
+			rows[i] = fmt.Sprintf(`{"offer_id":%d}`, page*10+i)
+		}
+		fmt.Fprintf(w, `{"status":true,"data":{"total":%d,"data":[%s]}}`, total, strings.Join(rows, ","))
+	}))
+	t.Cleanup(server.Close)
+
+	client := newAPIClient(&Config{BaseURL: server.URL, ClientKey: "1", PerPage: 2, Concurrency: 2, Timeout: 2 * time.Second, secret: "s"})
+	return client, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), requested...)
+	}
+}
+
+func offerIDs(rows []*IAARow) string {
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = row.Value("offer_id", defaultFormatOptions)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestFetchAllPages(t *testing.T) {
+	cases := []struct {
+		name     string
+		total    int
+		sizes    []int
+		want     string
+		maxPage  int
+		minPages int
+	}{
+		// pages past the reported total are never requested
+		{"total reached", 5, []int{2, 2, 1, 2, 2}, "10 11 20 21 30", 3, 3},
+		{"total on page 1", 2, []int{2, 2}, "10 11", 1, 1},
+		// an empty page ends the report even if the total says otherwise
+		{"empty page", 10, []int{2, 2}, "10 11 20 21", 5, 3},
+		{"empty first page", 0, nil, "", 1, 1},
+		// without a total pages are probed one batch of Concurrency at a time
+		{"unknown total", 0, []int{2, 2, 2, 1}, "10 11 20 21 30 31 40", 7, 5},
+	}
+	for _, c := range cases {
+		client, requested := pagedClient(t, c.total, c.sizes...)
+		window := backfillRange("2025-07-01", "2025-07-01")
+		rows, err := fetchAllPages(context.Background(), client, window)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := offerIDs(rows); got != c.want {
+			t.Errorf("%s: expected rows %q, got %q", c.name, c.want, got)
+		}
+		pages := requested()
+		maxPage := 0
+		for _, p := range pages {
+			maxPage = max(maxPage, p)
+		}
+		if maxPage > c.maxPage || len(pages) < c.minPages {
+			t.Errorf("%s: expected pages up to %d, got %v", c.name, c.maxPage, pages)
+		}
+	}
+}
+
+func TestFetchPages_FailureCancels(t *testing.T) {
+	sizes := []int{2, -1}
+	for i := 0; i < 18; i++ {
+		sizes = append(sizes, 2)
+	}
+	client, requested := pagedClient(t, 40, sizes...)
+	window := backfillRange("2025-07-01", "2025-07-01")
+
+	_, err := fetchAllPages(context.Background(), client, window)
+	if !errors.Is(err, ErrAuth) {
+		t.Fatalf("Expected the failing page's error, got %v", err)
+	}
+	// page 1 alone, then pages 2 and 3 in flight when page 2 fails; at
+	// most one more page can be handed out before the cancel is seen
+	if pages := requested(); len(pages) > 4 {
+		t.Errorf("Expected the remaining pages cancelled, got %v", pages)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fetchPages(ctx, client, window, []int{2, 3, 4}); err == nil {
+		t.Error("Expected a cancelled context to be an error, not empty pages")
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultQualityReport = "mobvista_violations.json"
+
+// Quality modes for -quality.
+const (
+	qualityOff    = "off"
+	qualityWarn   = "warn"
+	qualityStrict = "strict"
+)
+
+// Rule kinds.
+const (
+	ruleRequired  = "required"  // every field present and non-empty
+	ruleRange     = "range"     // every present field within [min, max]
+	ruleMonotonic = "monotonic" // present fields never go the wrong way, in order
+	ruleCompare   = "compare"   // left op right, both expressions over fields
+)
+
+// ErrQuality is returned in strict mode when an error-severity rule fails.
+var ErrQuality = errors.New("data quality check failed")
+
+// qualityRule is one declarative check on a row. Which fields are used
+// depends on Kind; see defaultQualityRules for one of each. A check whose
+// values are blank is skipped, except for required.
+type qualityRule struct {
+	Name      string   `json:"name"`
+	Kind      string   `json:"kind"`
+	Severity  string   `json:"severity,omitempty"` // "error" (default) or "warn"
+	Fields    []string `json:"fields,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Direction string   `json:"direction,omitempty"` // monotonic: "non_increasing" or "non_decreasing"
+	Left      string   `json:"left,omitempty"`
+	Op        string   `json:"op,omitempty"` // compare: < <= > >= == != or ~= (within relative tolerance)
+	Right     string   `json:"right,omitempty"`
+	Tolerance float64  `json:"tolerance,omitempty"` // absolute for monotonic, relative for ~=
+
+	fields      []string // every field the rule reads
+	left, right expr
+}
+
+func floatPtr(v float64) *float64 { return &v }
+
+// defaultQualityRules catch the problems that have reached the warehouse
+// before. quality_rules.example.json holds the same set as a starting point
+// for -rules.
+var defaultQualityRules = []*qualityRule{
+	{Name: "key_present", Kind: ruleRequired, Fields: masterKeyColumns},
+	{Name: "counts_non_negative", Kind: ruleRange, Fields: []string{"install", "impressions"}, Min: floatPtr(0)},
+	{Name: "retention_in_range", Kind: ruleRange, Fields: []string{"rr_d0", "rr_d1", "rr_d3", "rr_d7", "rr_d14", "rr_d30"}, Min: floatPtr(0), Max: floatPtr(1)},
+	{Name: "retention_non_increasing", Kind: ruleMonotonic, Direction: "non_increasing", Fields: []string{"rr_d0", "rr_d1", "rr_d3", "rr_d7", "rr_d14", "rr_d30"}},
+	{Name: "revenue_cumulative", Kind: ruleMonotonic, Direction: "non_decreasing", Fields: []string{"revenue_d0", "revenue_d1", "revenue_d3", "revenue_d7", "revenue_d14", "revenue_d30"}},
+	{Name: "roas_cumulative", Kind: ruleMonotonic, Direction: "non_decreasing", Fields: []string{"d0_roas", "d1_roas", "d3_roas", "d7_roas", "d14_roas", "d30_roas"}},
+	{Name: "roas_matches_revenue", Kind: ruleCompare, Severity: "warn", Left: "revenue_d7 / d7_roas", Op: "~=", Right: "revenue_d0 / d0_roas", Tolerance: 0.02},
+}
+
+// loadQualityRules reads a rule file ({"rules": [...]}); an empty path
+// gives the default rules. Every rule is compiled and its fields checked
+// against fixedFieldOrder so a typo fails up front rather than never firing.
+func loadQualityRules(path string) ([]*qualityRule, error) {
+	rules := defaultQualityRules
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var file struct {
+			Rules []*qualityRule `json:"rules"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("parsing rules %s: %v", path, err)
+		}
+		if len(file.Rules) == 0 {
+			return nil, fmt.Errorf("%s: no rules defined", path)
+		}
+		rules = file.Rules
+	}
+
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %q: %v", rule.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *qualityRule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = "error"
+	case "error", "warn":
+	default:
+		return fmt.Errorf("unknown severity %q (want error or warn)", r.Severity)
+	}
+
+	switch r.Kind {
+	case ruleRequired:
+	case ruleRange:
+		if r.Min == nil && r.Max == nil {
+			return fmt.Errorf("range needs min, max or both")
+		}
+	case ruleMonotonic:
+		if r.Direction != "non_increasing" && r.Direction != "non_decreasing" {
+			return fmt.Errorf("unknown direction %q (want non_increasing or non_decreasing)", r.Direction)
+		}
+		if len(r.Fields) < 2 {
+			return fmt.Errorf("monotonic needs at least two fields")
+		}
+	case ruleCompare:
+		var err error
+		if r.left, err = parseExpr(r.Left); err != nil {
+			return fmt.Errorf("left: %v", err)
+		}
+		if r.right, err = parseExpr(r.Right); err != nil {
+			return fmt.Errorf("right: %v", err)
+		}
+		switch r.Op {
+		case "<", "<=", ">", ">=", "==", "!=", "~=":
+		default:
+			return fmt.Errorf("unknown op %q", r.Op)
+		}
+	default:
+		return fmt.Errorf("unknown kind %q", r.Kind)
+	}
+	r.fields = r.Fields
+	if r.Kind == ruleCompare {
+		r.fields = append(exprFields(r.left), exprFields(r.right)...)
+	}
+	if len(r.fields) == 0 {
+		return fmt.Errorf("no fields")
+	}
+	for _, field := range r.fields {
+		if !contains(fixedFieldOrder, field) {
+			return fmt.Errorf("unknown field %q", field)
+		}
+	}
+	return nil
+}
+
+// check returns a description of what is wrong with row, or "".
+func (r *qualityRule) check(row *IAARow) string {
+	switch r.Kind {
+	case ruleRequired:
+		var missing []string
+		for _, field := range r.Fields {
+			if row.Value(field, defaultFormatOptions) == "" {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) > 0 {
+			return "missing " + strings.Join(missing, ", ")
+		}
+
+	case ruleRange:
+		for _, field := range r.Fields {
+			v, ok := rowFloat(row, field)
+			switch {
+			case !ok:
+			case r.Min != nil && v < *r.Min:
+				return fmt.Sprintf("%s %v below %v", field, v, *r.Min)
+			case r.Max != nil && v > *r.Max:
+				return fmt.Sprintf("%s %v above %v", field, v, *r.Max)
+			}
+		}
+
+	case ruleMonotonic:
+		prevField, prev := "", 0.0
+		for _, field := range r.Fields {
+			v, ok := rowFloat(row, field)
+			if !ok {
+				continue
+			}
+			if prevField != "" {
+				if r.Direction == "non_increasing" && v > prev+r.Tolerance {
+					return fmt.Sprintf("%s %v rises above %s %v", field, v, prevField, prev)
+				}
+				if r.Direction == "non_decreasing" && v < prev-r.Tolerance {
+					return fmt.Sprintf("%s %v drops below %s %v", field, v, prevField, prev)
+				}
+			}
+			prevField, prev = field, v
+		}
+
+	case ruleCompare:
+		lr, ok := r.left.eval(row)
+		if !ok {
+			return ""
+		}
+		rr, ok := r.right.eval(row)
+		if !ok {
+			return ""
+		}
+		l, _ := lr.Float64()
+		rv, _ := rr.Float64()
+		if !compareOp(r.Op, l, rv, r.Tolerance) {
+			return fmt.Sprintf("%s = %v, %s = %v, want %s", r.Left, l, r.Right, rv, r.Op)
+		}
+	}
+	return ""
+}
+
+func compareOp(op string, l, r, tolerance float64) bool {
+	switch op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	default: // ~=
+		return math.Abs(l-r) <= tolerance*math.Max(math.Abs(l), math.Abs(r))
+	}
+}
+
+func rowFloat(row *IAARow, field string) (float64, bool) {
+	r, ok := rowRat(row, field)
+	if !ok {
+		return 0, false
+	}
+	v, _ := r.Float64()
+	return v, true
+}
+
+// violation is one failed rule on one row.
+type violation struct {
+	Key      map[string]string `json:"key"`
+	Rule     string            `json:"rule"`
+	Severity string            `json:"severity"`
+	Message  string            `json:"message"`
+	Values   map[string]string `json:"values"`
+}
+
+// qualityReport is written to -quality-report.
+type qualityReport struct {
+	Window     string         `json:"window"`
+	CheckedAt  string         `json:"checked_at"`
+	Rows       int            `json:"rows"`
+	Errors     int            `json:"errors"`
+	Warnings   int            `json:"warnings"`
+	ByRule     map[string]int `json:"by_rule,omitempty"`
+	Violations []violation    `json:"violations"`
+}
+
+// qualityChecker runs rules over rows as they are exported.
+type qualityChecker struct {
+	rules  []*qualityRule
+	report qualityReport
+}
+
+func newQualityChecker(rules []*qualityRule, window DateRange) *qualityChecker {
+	return &qualityChecker{rules: rules, report: qualityReport{
+		Window:     window.String(),
+		ByRule:     make(map[string]int),
+		Violations: []violation{},
+	}}
+}
+
+func (c *qualityChecker) inspect(row *IAARow) {
+	c.report.Rows++
+	for _, rule := range c.rules {
+		msg := rule.check(row)
+		if msg == "" {
+			continue
+		}
+		v := violation{
+			Key:      make(map[string]string, len(masterKeyColumns)),
+			Rule:     rule.Name,
+			Severity: rule.Severity,
+			Message:  msg,
+			Values:   make(map[string]string, len(rule.fields)),
+		}
+		for _, field := range masterKeyColumns {
+			v.Key[field] = row.Value(field, defaultFormatOptions)
+		}
+		for _, field := range rule.fields {
+			v.Values[field] = row.Value(field, defaultFormatOptions)
+		}
+		c.report.Violations = append(c.report.Violations, v)
+		c.report.ByRule[rule.Name]++
+		if rule.Severity == "warn" {
+			c.report.Warnings++
+		} else {
+			c.report.Errors++
+		}
+	}
+}
+
+func (c *qualityChecker) String() string {
+	if len(c.report.Violations) == 0 {
+		return fmt.Sprintf("%d rows passed %d rules", c.report.Rows, len(c.rules))
+	}
+	parts := make([]string, 0, len(c.report.ByRule))
+	for _, name := range sortedKeys(c.report.ByRule) {
+		parts = append(parts, fmt.Sprintf("%s %d", name, c.report.ByRule[name]))
+	}
+	return fmt.Sprintf("%d rows, %d errors, %d warnings (%s)", c.report.Rows, c.report.Errors, c.report.Warnings, strings.Join(parts, ", "))
+}
+
+// finish writes the report and, in strict mode, fails on any error.
+func (c *qualityChecker) finish(path, mode string) error {
+	c.report.CheckedAt = time.Now().Format(time.RFC3339)
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(c.report)
+	})
+	if err != nil {
+		return fmt.Errorf("saving quality report %s: %v", path, err)
+	}
+	if mode == qualityStrict && c.report.Errors > 0 {
+		return fmt.Errorf("%w: %s", ErrQuality, c)
+	}
+	return nil
+}
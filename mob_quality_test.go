@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQualityRules_Defaults(t *testing.T) {
+	rules, err := loadQualityRules("")
+	if err != nil {
+		t.Fatalf("default rules do not compile: %v", err)
+	}
+	checker := newQualityChecker(rules, DateRange{Start: time.Now(), End: time.Now()})
+
+	good := decodeRow(t, `{"date":"2025-07-04","channel_id":1,"offer_id":2,"install":100,"impressions":900,
+		"rr_d0":1,"rr_d1":0.4,"rr_d3":0.3,"revenue_d0":10,"revenue_d7":25,"d0_roas":0.1,"d7_roas":0.25}`)
+	checker.inspect(good)
+	if len(checker.report.Violations) != 0 {
+		t.Fatalf("Expected a clean row to pass, got %+v", checker.report.Violations)
+	}
+
+	bad := decodeRow(t, `{"date":"2025-07-04","channel_id":1,"install":-5,
+		"rr_d1":0.3,"rr_d3":0.35,"revenue_d1":12,"revenue_d3":11,"d0_roas":0.1,"d7_roas":0.2,"revenue_d0":10,"revenue_d7":25}`)
+	checker.inspect(bad)
+	got := map[string]bool{}
+	for _, v := range checker.report.Violations {
+		got[v.Rule] = true
+	}
+	for _, rule := range []string{"key_present", "counts_non_negative", "retention_non_increasing", "revenue_cumulative", "roas_matches_revenue"} {
+		if !got[rule] {
+			t.Errorf("Expected %s to fire on the bad row", rule)
+		}
+	}
+	if checker.report.Errors != 4 || checker.report.Warnings != 1 {
+		t.Errorf("Expected 4 errors and 1 warning, got %d and %d", checker.report.Errors, checker.report.Warnings)
+	}
+	v := checker.report.Violations[0]
+	if v.Key["date"] != "2025-07-04" || v.Key["offer_id"] != "" {
+		t.Errorf("Expected violation keyed by date/channel_id/offer_id, got %v", v.Key)
+	}
+}
+
+func TestQualityRules_ExampleMatchesDefaults(t *testing.T) {
+	rules, err := loadQualityRules("quality_rules.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	example, _ := json.Marshal(rules)
+	defaults, _ := json.Marshal(defaultQualityRules)
+	if string(example) != string(defaults) {
+		t.Errorf("quality_rules.example.json drifted from defaultQualityRules:\n%s\n%s", example, defaults)
+	}
+}
+
+func TestQualityRules_Rejects(t *testing.T) {
+	for _, rules := range []string{
+		`{"rules":[{"name":"a","kind":"range","fields":["install"]}]}`,
+		`{"rules":[{"name":"a","kind":"range","fields":["instal"],"min":0}]}`,
+		`{"rules":[{"name":"a","kind":"monotonic","direction":"up","fields":["rr_d1","rr_d3"]}]}`,
+		`{"rules":[{"name":"a","kind":"compare","left":"install","op":"=>","right":"0"}]}`,
+		`{"rules":[{"name":"a","kind":"required","fields":["date"],"severity":"fatal"}]}`,
+		`{"rules":[{"name":"a","kind":"required","fields":["date"]},{"name":"a","kind":"required","fields":["date"]}]}`,
+	} {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		os.WriteFile(path, []byte(rules), 0644)
+		if _, err := loadQualityRules(path); err == nil {
+			t.Errorf("Expected %s to be rejected", rules)
+		}
+	}
+}
+
+func TestQualityChecker_Strict(t *testing.T) {
+	rules, _ := loadQualityRules("")
+	checker := newQualityChecker(rules, DateRange{})
+	checker.inspect(decodeRow(t, `{"date":"2025-07-04","channel_id":1,"offer_id":2,"install":-1}`))
+
+	report := filepath.Join(t.TempDir(), "violations.json")
+	if err := checker.finish(report, qualityWarn); err != nil {
+		t.Errorf("warn mode should not fail, got %v", err)
+	}
+	err := checker.finish(report, qualityStrict)
+	if !errors.Is(err, ErrQuality) || exitCode(err) != 9 {
+		t.Errorf("Expected ErrQuality with exit code 9, got %v", err)
+	}
+	data, _ := os.ReadFile(report)
+	var written qualityReport
+	if err := json.Unmarshal(data, &written); err != nil || written.Errors != 1 || !strings.Contains(written.Violations[0].Message, "install -1 below 0") {
+		t.Errorf("Unexpected report %s (%v)", data, err)
+	}
+	if !reflect.DeepEqual(written.Violations[0].Values, map[string]string{"install": "-1", "impressions": ""}) {
+		t.Errorf("Expected rule values in report, got %v", written.Violations[0].Values)
+	}
+}
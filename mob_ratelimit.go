@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by every request of a run, so
+// concurrent windows or accounts cannot exceed the API's rate together.
+type rateLimiter struct {
+	tokens chan struct{}
+	cancel context.CancelFunc
+}
+
+// newRateLimiter allows qps requests per second on average with bursts of
+// up to burst requests. The bucket starts full.
+func newRateLimiter(qps float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	tokens := make(chan struct{}, burst)
+	for i := 0; i < burst; i++ {
+		tokens <- struct{}{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / qps))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case tokens <- struct{}{}:
+				default: // bucket full, drop the token
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return &rateLimiter{tokens: tokens, cancel: cancel}
+}
+
+// Wait blocks until a token is available or ctx is done. A nil limiter
+// never blocks.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-l.tokens:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *rateLimiter) Stop() {
+	if l != nil {
+		l.cancel()
+	}
+}
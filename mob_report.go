@@ -0,0 +1,484 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultReportDecimals = 4
+
+// reportDims are dimensions derived from the date column rather than read
+// from one. Weeks start on Monday and are labelled by that date.
+var reportDims = map[string]func(date time.Time) string{
+	"week": func(date time.Time) string {
+		return date.AddDate(0, 0, -(int(date.Weekday())+6)%7).Format(dateLayout)
+	},
+	"month": func(date time.Time) string { return date.Format("2006-01") },
+}
+
+// reportSums are the count columns added up as they are.
+var reportSums = []string{"install", "impressions"}
+
+type reportOptions struct {
+	By       []string
+	Sort     string // output column; dimensions when empty
+	Desc     bool
+	Top      int // 0 keeps every group
+	Decimals int // places for retention, ROAS and spend
+	// Maturity, when set, limits each day-N metric to the rows whose
+	// cohort is complete for day N, so young install dates do not pull
+	// the aggregate down with partial values.
+	Maturity *maturity
+}
+
+// reportGroup accumulates the rows sharing one set of dimension values.
+// Ratios are never averaged: retention is weighted by installs and ROAS
+// is summed revenue over summed spend.
+type reportGroup struct {
+	dims       []string
+	rows       int
+	sums       map[string]*big.Rat // reportSums and revenue_dN
+	spend      *big.Rat
+	rrSum      map[int]*big.Rat // sum of rr_dN * install
+	rrInstall  map[int]*big.Rat // installs of the rows that have rr_dN
+	revInstall map[int]*big.Rat // installs of the rows that have revenue_dN
+	roasRev    map[int]*big.Rat // revenue_dN of the rows whose spend is known
+	roasSpend  map[int]*big.Rat
+}
+
+func addRat(m map[int]*big.Rat, day int, v *big.Rat) {
+	if m[day] == nil {
+		m[day] = new(big.Rat)
+	}
+	m[day].Add(m[day], v)
+}
+
+// reportBuilder groups rows from one or more exports.
+type reportBuilder struct {
+	opts   reportOptions
+	seen   map[string]bool // summed columns present in any input
+	days   map[int]bool    // cohort days present in any input
+	places map[string]int  // most decimals seen per summed column
+	groups map[string]*reportGroup
+}
+
+func newReportBuilder(opts reportOptions) *reportBuilder {
+	return &reportBuilder{
+		opts:   opts,
+		seen:   make(map[string]bool),
+		days:   make(map[int]bool),
+		places: make(map[string]int),
+		groups: make(map[string]*reportGroup),
+	}
+}
+
+// add groups the records of one export. Column positions come from its
+// own header, so exports with different layouts can be combined.
+func (b *reportBuilder) add(header []string, records [][]string) error {
+	index := make(map[string]int, len(header))
+	for i, column := range header {
+		index[column] = i
+		if contains(reportSums, column) {
+			b.seen[column] = true
+		}
+		if day := cohortDay(column); day >= 0 {
+			b.days[day] = true
+		}
+	}
+	for _, dim := range b.opts.By {
+		_, derived := reportDims[dim]
+		if _, ok := index[dim]; !ok && !(derived && hasColumn(index, "date")) {
+			return fmt.Errorf("no column %q to group by", dim)
+		}
+	}
+
+	get := func(record []string, column string) (*big.Rat, bool) {
+		i, ok := index[column]
+		if !ok || i >= len(record) {
+			return nil, false
+		}
+		return new(big.Rat).SetString(strings.TrimSpace(record[i]))
+	}
+
+	for _, record := range records {
+		dims := make([]string, len(b.opts.By))
+		for i, dim := range b.opts.By {
+			if j, ok := index[dim]; ok && j < len(record) {
+				dims[i] = record[j]
+				continue
+			}
+			date, err := time.Parse(dateLayout, record[index["date"]])
+			if err != nil {
+				return fmt.Errorf("bad date %q: %v", record[index["date"]], err)
+			}
+			dims[i] = reportDims[dim](date)
+		}
+		key := strings.Join(dims, "\x1f")
+		g := b.groups[key]
+		if g == nil {
+			g = &reportGroup{
+				dims:       dims,
+				sums:       make(map[string]*big.Rat),
+				spend:      new(big.Rat),
+				rrSum:      make(map[int]*big.Rat),
+				rrInstall:  make(map[int]*big.Rat),
+				revInstall: make(map[int]*big.Rat),
+				roasRev:    make(map[int]*big.Rat),
+				roasSpend:  make(map[int]*big.Rat),
+			}
+			b.groups[key] = g
+		}
+		g.rows++
+
+		age, dated := 0, false
+		if b.opts.Maturity != nil && hasColumn(index, "date") {
+			age, dated = b.opts.Maturity.ageOf(record[index["date"]])
+		}
+		mature := func(day int) bool {
+			return b.opts.Maturity == nil || (dated && b.opts.Maturity.mature(age, day))
+		}
+
+		install, hasInstall := get(record, "install")
+		for _, column := range header {
+			revenueDay := -1
+			if strings.HasPrefix(column, "revenue_d") {
+				revenueDay = cohortDay(column)
+			}
+			if contains(reportSums, column) || revenueDay >= 0 {
+				v, ok := get(record, column)
+				if !ok || (revenueDay >= 0 && !mature(revenueDay)) {
+					continue
+				}
+				if revenueDay >= 0 && hasInstall {
+					addRat(g.revInstall, revenueDay, install)
+				}
+				if g.sums[column] == nil {
+					g.sums[column] = new(big.Rat)
+				}
+				g.sums[column].Add(g.sums[column], v)
+				b.places[column] = max(b.places[column], decimalPlaces(record[index[column]]))
+			}
+		}
+
+		spend := impliedSpend(record, get, b.days)
+		if spend != nil {
+			g.spend.Add(g.spend, spend)
+		}
+		for day := range b.days {
+			if !mature(day) {
+				continue
+			}
+			if rr, ok := get(record, fmt.Sprintf("rr_d%d", day)); ok && hasInstall {
+				addRat(g.rrSum, day, new(big.Rat).Mul(rr, install))
+				addRat(g.rrInstall, day, install)
+			}
+			if rev, ok := get(record, fmt.Sprintf("revenue_d%d", day)); ok && spend != nil {
+				addRat(g.roasRev, day, rev)
+				addRat(g.roasSpend, day, spend)
+			}
+		}
+	}
+	return nil
+}
+
+// sortedGroups returns the groups ordered by their dimension values.
+func (b *reportBuilder) sortedGroups() []*reportGroup {
+	groups := make([]*reportGroup, 0, len(b.groups))
+	for _, g := range b.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		for k := range groups[i].dims {
+			if c := compareCells(groups[i].dims[k], groups[j].dims[k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return groups
+}
+
+// retention is the install-weighted rr_dN of the group.
+func (g *reportGroup) retention(day int) (float64, bool) {
+	if g.rrInstall[day] == nil || g.rrInstall[day].Sign() == 0 {
+		return 0, false
+	}
+	v, _ := new(big.Rat).Quo(g.rrSum[day], g.rrInstall[day]).Float64()
+	return v, true
+}
+
+// revenue is the summed revenue_dN of the group.
+func (g *reportGroup) revenue(day int) (float64, bool) {
+	sum := g.sums[fmt.Sprintf("revenue_d%d", day)]
+	if sum == nil {
+		return 0, false
+	}
+	v, _ := sum.Float64()
+	return v, true
+}
+
+// roas is revenue_dN over spend for the rows whose spend is known.
+func (g *reportGroup) roas(day int) (float64, bool) {
+	if g.roasSpend[day] == nil || g.roasSpend[day].Sign() == 0 {
+		return 0, false
+	}
+	v, _ := new(big.Rat).Quo(g.roasRev[day], g.roasSpend[day]).Float64()
+	return v, true
+}
+
+// revenuePerInstall is revenue_dN over the installs of the rows that
+// have it. Unlike revenue it stays comparable across days when Maturity
+// leaves a different set of rows behind each day.
+func (g *reportGroup) revenuePerInstall(day int) (float64, bool) {
+	sum := g.sums[fmt.Sprintf("revenue_d%d", day)]
+	if sum == nil || g.revInstall[day] == nil || g.revInstall[day].Sign() == 0 {
+		return 0, false
+	}
+	v, _ := new(big.Rat).Quo(sum, g.revInstall[day]).Float64()
+	return v, true
+}
+
+func (g *reportGroup) installs() float64 {
+	if g.sums["install"] == nil {
+		return 0
+	}
+	v, _ := g.sums["install"].Float64()
+	return v
+}
+
+func (g *reportGroup) spendTotal() float64 {
+	v, _ := g.spend.Float64()
+	return v
+}
+
+func hasColumn(index map[string]int, column string) bool {
+	_, ok := index[column]
+	return ok
+}
+
+// impliedSpend recovers what was spent on a row from revenue_dN / dN_roas,
+// using the latest day with a non-zero ROAS since it carries the least
+// rounding error. It returns nil when no day allows it.
+func impliedSpend(record []string, get func([]string, string) (*big.Rat, bool), days map[int]bool) *big.Rat {
+	best := -1
+	var spend *big.Rat
+	for day := range days {
+		if day <= best {
+			continue
+		}
+		roas, ok := get(record, fmt.Sprintf("d%d_roas", day))
+		if !ok || roas.Sign() <= 0 {
+			continue
+		}
+		rev, ok := get(record, fmt.Sprintf("revenue_d%d", day))
+		if !ok {
+			continue
+		}
+		best, spend = day, new(big.Rat).Quo(rev, roas)
+	}
+	return spend
+}
+
+// columns returns the report header: dimensions, row count, sums, spend,
+// then per cohort day revenue, retention and ROAS.
+func (b *reportBuilder) columns() []string {
+	columns := append(append([]string(nil), b.opts.By...), "rows")
+	for _, column := range reportSums {
+		if b.seen[column] {
+			columns = append(columns, column)
+		}
+	}
+	columns = append(columns, "spend")
+	days := b.sortedDays()
+	for _, prefix := range []string{"revenue_d%d", "rr_d%d", "d%d_roas"} {
+		for _, day := range days {
+			columns = append(columns, fmt.Sprintf(prefix, day))
+		}
+	}
+	return columns
+}
+
+func (b *reportBuilder) sortedDays() []int {
+	days := make([]int, 0, len(b.days))
+	for day := range b.days {
+		days = append(days, day)
+	}
+	sort.Ints(days)
+	return days
+}
+
+// rows returns the groups as formatted values in columns() order, sorted
+// and cut to Top.
+func (b *reportBuilder) rows() ([][]string, error) {
+	columns := b.columns()
+	sortIndex := -1
+	if b.opts.Sort != "" {
+		for i, column := range columns {
+			if column == b.opts.Sort {
+				sortIndex = i
+			}
+		}
+		if sortIndex < 0 {
+			return nil, fmt.Errorf("cannot sort by %q: not a report column (%s)", b.opts.Sort, strings.Join(columns, ", "))
+		}
+	}
+
+	ratio := func(num, den *big.Rat) string {
+		if num == nil || den == nil || den.Sign() == 0 {
+			return ""
+		}
+		return new(big.Rat).Quo(num, den).FloatString(b.opts.Decimals)
+	}
+	days := b.sortedDays()
+	rows := make([][]string, 0, len(b.groups))
+	for _, g := range b.groups {
+		row := append(append([]string(nil), g.dims...), strconv.Itoa(g.rows))
+		for _, column := range reportSums {
+			if b.seen[column] {
+				row = append(row, formatSum(g.sums[column], b.places[column]))
+			}
+		}
+		if g.spend.Sign() > 0 {
+			row = append(row, g.spend.FloatString(b.opts.Decimals))
+		} else {
+			row = append(row, "")
+		}
+		for _, day := range days {
+			column := fmt.Sprintf("revenue_d%d", day)
+			row = append(row, formatSum(g.sums[column], b.places[column]))
+		}
+		for _, day := range days {
+			row = append(row, ratio(g.rrSum[day], g.rrInstall[day]))
+		}
+		for _, day := range days {
+			row = append(row, ratio(g.roasRev[day], g.roasSpend[day]))
+		}
+		rows = append(rows, row)
+	}
+
+	dimCount := len(b.opts.By)
+	sort.Slice(rows, func(i, j int) bool {
+		if sortIndex >= 0 {
+			if c := compareCells(rows[i][sortIndex], rows[j][sortIndex]); c != 0 {
+				return (c < 0) != b.opts.Desc
+			}
+		}
+		for k := 0; k < dimCount; k++ {
+			if c := compareCells(rows[i][k], rows[j][k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	if b.opts.Top > 0 && len(rows) > b.opts.Top {
+		rows = rows[:b.opts.Top]
+	}
+	return rows, nil
+}
+
+func formatSum(v *big.Rat, places int) string {
+	if v == nil {
+		return ""
+	}
+	return v.FloatString(places)
+}
+
+// compareCells orders numbers numerically and anything else as text;
+// blanks sort first.
+func compareCells(a, b string) int {
+	ra, okA := new(big.Rat).SetString(a)
+	rb, okB := new(big.Rat).SetString(b)
+	if okA && okB {
+		return ra.Cmp(rb)
+	}
+	return strings.Compare(a, b)
+}
+
+// runReport rolls exported rows up by the chosen dimensions.
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	by := fs.String("by", "package", "comma-separated columns to group by; week and month are derived from date")
+	sortBy := fs.String("sort", "", "report column to sort by (default the -by columns)")
+	desc := fs.Bool("desc", false, "sort descending")
+	top := fs.Int("top", 0, "keep only the first N groups after sorting (0 keeps all)")
+	decimals := fs.Int("decimals", defaultReportDecimals, "decimal places for spend, retention and ROAS")
+	output := fs.String("o", "", "write the report to this file instead of stdout")
+	exportOpts := ExportOptions{}
+	fs.StringVar(&exportOpts.Format, "format", "csv", "output format: "+formatNames())
+	fs.BoolVar(&exportOpts.BOM, "bom", false, "start CSV/TSV output with a UTF-8 BOM")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s report [flags] EXPORT...\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if _, ok := outputFormats[exportOpts.Format]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q (want one of %s)\n", exportOpts.Format, formatNames())
+		os.Exit(2)
+	}
+	dims := splitList(*by)
+	if fs.NArg() == 0 || len(dims) == 0 || *top < 0 || *decimals < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	builder := newReportBuilder(reportOptions{
+		By:       dims,
+		Sort:     *sortBy,
+		Desc:     *desc,
+		Top:      *top,
+		Decimals: *decimals,
+	})
+	for _, path := range fs.Args() {
+		header, records, err := readExportFile(path)
+		if err == nil {
+			err = builder.add(header, records)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(1)
+		}
+	}
+	rows, err := builder.rows()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	exportOpts.Title = "Mobvista IAA by " + strings.Join(builder.opts.By, ", ")
+	write := func(w io.Writer) error {
+		exporter, err := newExporter(w, exportOpts)
+		if err != nil {
+			return err
+		}
+		if err := exporter.Begin(builder.columns()); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := exporter.WriteRow(row); err != nil {
+				return err
+			}
+		}
+		return exporter.End()
+	}
+	if *output == "" {
+		err = write(os.Stdout)
+	} else {
+		err = writeFileAtomic(*output, write)
+		if err == nil {
+			fmt.Printf("%d groups written to %s\n", len(rows), *output)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "writing report failed:", err)
+		os.Exit(1)
+	}
+}
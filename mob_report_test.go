@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var reportHeader = []string{"date", "channel_id", "offer_id", "package", "install", "impressions", "rr_d1", "rr_d7", "d1_roas", "d7_roas", "revenue_d1", "revenue_d7"}
+
+var reportRecords = [][]string{
+	// spend 100 and 400: ROAS d7 must be (50+100)/(100+400) = 0.3, not
+	// the average of 0.5 and 0.25
+	{"2025-07-07", "1", "10", "com.a", "100", "1000", "0.5", "0.2", "0.3", "0.5", "30", "50"},
+	{"2025-07-08", "1", "11", "com.a", "300", "2000", "0.3", "0.1", "0.1", "0.25", "40", "100"},
+	{"2025-07-13", "2", "12", "com.b", "50", "500", "0.4", "", "0.2", "", "10.5", ""},
+	{"2025-07-14", "2", "12", "com.b", "10", "100", "0.2", "", "0", "", "0", ""},
+}
+
+func buildReport(t *testing.T, opts reportOptions) ([]string, [][]string) {
+	t.Helper()
+	b := newReportBuilder(opts)
+	if err := b.add(reportHeader, reportRecords); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := b.rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.columns(), rows
+}
+
+func TestReport_WeightedRatios(t *testing.T) {
+	columns, rows := buildReport(t, reportOptions{By: []string{"package"}, Decimals: 4})
+	if got := strings.Join(columns, ","); got != "package,rows,install,impressions,spend,revenue_d1,revenue_d7,rr_d1,rr_d7,d1_roas,d7_roas" {
+		t.Fatalf("Unexpected columns %s", got)
+	}
+	// rr_d1 = (0.5*100 + 0.3*300) / 400 = 0.35
+	want := "com.a,2,400,3000,500.0000,70.0,150,0.3500,0.1250,0.1400,0.3000"
+	if got := strings.Join(rows[0], ","); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	// the second com.b row has no non-zero ROAS, so no spend: it counts
+	// toward sums and retention but not ROAS
+	want = "com.b,2,60,600,52.5000,10.5,,0.3667,,0.2000,"
+	if got := strings.Join(rows[1], ","); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestReport_WeekSortTop(t *testing.T) {
+	_, rows := buildReport(t, reportOptions{By: []string{"week", "channel_id"}, Sort: "install", Desc: true, Top: 2})
+	if len(rows) != 2 || rows[0][0] != "2025-07-07" || rows[0][3] != "400" || rows[1][0] != "2025-07-07" || rows[1][1] != "2" {
+		t.Errorf("Unexpected weekly rows %v", rows)
+	}
+}
+
+func TestReport_Rejects(t *testing.T) {
+	b := newReportBuilder(reportOptions{By: []string{"country"}})
+	if err := b.add(reportHeader, reportRecords); err == nil {
+		t.Error("Expected unknown dimension to be rejected")
+	}
+	b = newReportBuilder(reportOptions{By: []string{"package"}, Sort: "ltv"})
+	b.add(reportHeader, reportRecords)
+	if _, err := b.rows(); err == nil {
+		t.Error("Expected unknown sort column to be rejected")
+	}
+}
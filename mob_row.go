@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Number is a numeric report value that keeps the literal the API sent, so
+// IDs and money are never round-tripped through float64. The API is not
+// consistent about types, so both 1.5 and "1.5" decode; "" and null leave
+// the value unset.
+type Number struct {
+	raw   string
+	Valid bool
+}
+
+func (n *Number) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if bytes.Equal(b, []byte("null")) {
+		*n = Number{}
+		return nil
+	}
+	s := string(b)
+	if len(b) > 0 && b[0] == '"' {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			*n = Number{}
+			return nil
+		}
+	}
+	// big.Rat would also take 1/3, 0x10 and +5, which are not JSON numbers
+	// and would be written to exports verbatim.
+	if !jsonNumber.MatchString(s) {
+		return fmt.Errorf("not a number: %s", b)
+	}
+	*n = Number{raw: s, Valid: true}
+	return nil
+}
+
+func (n Number) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return []byte(n.raw), nil
+}
+
+// Float64 returns the value for arithmetic; unset values are zero.
+func (n Number) Float64() float64 {
+	if !n.Valid {
+		return 0
+	}
+	f, _ := new(big.Rat).SetString(n.raw)
+	v, _ := f.Float64()
+	return v
+}
+
+// Format renders the value with exactly prec decimal places, rounding half
+// away from zero on the exact decimal value. A negative prec returns the
+// literal as received, except that exponent forms are expanded.
+func (n Number) Format(prec int) string {
+	if !n.Valid {
+		return ""
+	}
+	r, _ := new(big.Rat).SetString(n.raw)
+	if prec >= 0 {
+		return r.FloatString(prec)
+	}
+	if !strings.ContainsAny(n.raw, "eE") {
+		return n.raw
+	}
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	s := r.FloatString(20)
+	return strings.TrimRight(strings.TrimRight(s, "0"), ".")
+}
+
+// Text is a string column that tolerates the API sending a bare number.
+type Text string
+
+func (t *Text) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if bytes.Equal(b, []byte("null")) {
+		*t = ""
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*t = Text(s)
+		return nil
+	}
+	*t = Text(b)
+	return nil
+}
+
+// IAARow is one row of the /channel/iaa/v1 report. Fields the API returns
+// that are not listed here are kept verbatim in Extras.
+type IAARow struct {
+	Date        Text
+	ChannelID   Number
+	ChannelUUID Text
+	OfferID     Number
+	OfferUUID   Text
+	Package     Text
+	Install     Number
+	Impressions Number
+
+	RRD0, RRD1, RRD3, RRD7, RRD14, RRD30             Number
+	D0ROAS, D1ROAS, D3ROAS, D7ROAS, D14ROAS, D30ROAS Number
+
+	RevenueD0, RevenueD1, RevenueD3, RevenueD7, RevenueD14, RevenueD30 Number
+
+	Extras map[string]json.RawMessage
+
+	kinds   map[string]string // JSON type of every field as received
+	account string            // profile the row was fetched with, under -accounts
+}
+
+// field returns a pointer to the struct field behind an API field name, or
+// nil when the name is not part of the typed schema.
+func (r *IAARow) field(name string) interface{} {
+	switch name {
+	case "date":
+		return &r.Date
+	case "channel_id":
+		return &r.ChannelID
+	case "channel_uuid":
+		return &r.ChannelUUID
+	case "offer_id":
+		return &r.OfferID
+	case "offer_uuid":
+		return &r.OfferUUID
+	case "package":
+		return &r.Package
+	case "install":
+		return &r.Install
+	case "impressions":
+		return &r.Impressions
+	case "rr_d0":
+		return &r.RRD0
+	case "rr_d1":
+		return &r.RRD1
+	case "rr_d3":
+		return &r.RRD3
+	case "rr_d7":
+		return &r.RRD7
+	case "rr_d14":
+		return &r.RRD14
+	case "rr_d30":
+		return &r.RRD30
+	case "d0_roas":
+		return &r.D0ROAS
+	case "d1_roas":
+		return &r.D1ROAS
+	case "d3_roas":
+		return &r.D3ROAS
+	case "d7_roas":
+		return &r.D7ROAS
+	case "d14_roas":
+		return &r.D14ROAS
+	case "d30_roas":
+		return &r.D30ROAS
+	case "revenue_d0":
+		return &r.RevenueD0
+	case "revenue_d1":
+		return &r.RevenueD1
+	case "revenue_d3":
+		return &r.RevenueD3
+	case "revenue_d7":
+		return &r.RevenueD7
+	case "revenue_d14":
+		return &r.RevenueD14
+	case "revenue_d30":
+		return &r.RevenueD30
+	}
+	return nil
+}
+
+func (r *IAARow) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	return r.setFields(raw)
+}
+
+// setFields replaces r with the fields of one decoded API object.
+func (r *IAARow) setFields(raw map[string]json.RawMessage) error {
+	*r = IAARow{kinds: make(map[string]string, len(raw))}
+	for name, value := range raw {
+		r.kinds[name] = jsonKind(value)
+		target := r.field(name)
+		if target == nil {
+			if r.Extras == nil {
+				r.Extras = make(map[string]json.RawMessage)
+			}
+			r.Extras[name] = value
+			continue
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			return fmt.Errorf("field %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (r *IAARow) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(fixedFieldOrder)+len(r.Extras))
+	for _, name := range fixedFieldOrder {
+		out[name] = r.field(name)
+	}
+	for name, value := range r.Extras {
+		out[name] = value
+	}
+	return json.Marshal(out)
+}
+
+// jsonKind names the JSON type of a raw value.
+func jsonKind(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return "null"
+	}
+	switch raw[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "bool"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// ExtraNames returns the names of the untyped fields in sorted order.
+func (r *IAARow) ExtraNames() []string {
+	names := make([]string, 0, len(r.Extras))
+	for name := range r.Extras {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FormatOptions controls how numeric columns are written. A negative
+// precision keeps the literal the API returned.
+type FormatOptions struct {
+	RateDecimals    int
+	ROASDecimals    int
+	RevenueDecimals int
+}
+
+var defaultFormatOptions = FormatOptions{RateDecimals: -1, ROASDecimals: -1, RevenueDecimals: -1}
+
+func (o FormatOptions) precision(name string) int {
+	switch {
+	case strings.HasPrefix(name, "rr_d"):
+		return o.RateDecimals
+	case strings.HasSuffix(name, "_roas"):
+		return o.ROASDecimals
+	case strings.HasPrefix(name, "revenue_d"):
+		return o.RevenueDecimals
+	}
+	return -1
+}
+
+// Value returns the column as it should appear in an export.
+func (r *IAARow) Value(name string, opts FormatOptions) string {
+	switch v := r.field(name).(type) {
+	case *Text:
+		return string(*v)
+	case *Number:
+		return v.Format(opts.precision(name))
+	}
+	raw, ok := r.Extras[name]
+	if !ok {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	if string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestIAARow_Decode(t *testing.T) {
+	data := `{
+		"date": "2025-07-04",
+		"channel_id": 12345678901234,
+		"offer_id": "98765432109",
+		"package": "com.example.game",
+		"install": "1200",
+		"impressions": 3.5e6,
+		"rr_d1": 0.41666666,
+		"d7_roas": "0.125",
+		"revenue_d7": null,
+		"campaign_type": "iaa"
+	}`
+
+	var row IAARow
+	if err := json.Unmarshal([]byte(data), &row); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+
+	opts := defaultFormatOptions
+	cases := map[string]string{
+		"date":        "2025-07-04",
+		"channel_id":  "12345678901234",
+		"offer_id":    "98765432109",
+		"install":     "1200",
+		"impressions": "3500000",
+		"rr_d1":       "0.41666666",
+		"d7_roas":     "0.125",
+		"revenue_d7":  "",
+		"rr_d30":      "",
+	}
+	for field, want := range cases {
+		if got := row.Value(field, opts); got != want {
+			t.Errorf("%s: expected %q, got %q", field, want, got)
+		}
+	}
+
+	if got := row.Value("campaign_type", opts); got != "iaa" {
+		t.Errorf("extra field campaign_type: expected %q, got %q", "iaa", got)
+	}
+}
+
+func TestIAARow_FormatPrecision(t *testing.T) {
+	var row IAARow
+	if err := json.Unmarshal([]byte(`{"rr_d1":"0.41665","d7_roas":1.005,"revenue_d7":"12"}`), &row); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+
+	opts := FormatOptions{RateDecimals: 4, ROASDecimals: 2, RevenueDecimals: 2}
+	if got := row.Value("rr_d1", opts); got != "0.4167" {
+		t.Errorf("rr_d1: expected 0.4167, got %s", got)
+	}
+	if got := row.Value("d7_roas", opts); got != "1.01" {
+		t.Errorf("d7_roas: expected 1.01, got %s", got)
+	}
+	if got := row.Value("revenue_d7", opts); got != "12.00" {
+		t.Errorf("revenue_d7: expected 12.00, got %s", got)
+	}
+}
+
+func TestIAARow_RejectsBadNumber(t *testing.T) {
+	var row IAARow
+	for _, value := range []string{`"lots"`, `"1/3"`, `"0x10"`, `"+5"`, `"1e"`, `".5"`} {
+		if err := json.Unmarshal([]byte(`{"install":`+value+`}`), &row); err == nil {
+			t.Errorf("Expected error for install %s, got nil", value)
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"install":"-1.5e3"}`), &row); err != nil || row.Value("install", defaultFormatOptions) != "-1500" {
+		t.Errorf("Expected -1.5e3 accepted as -1500, got %q (%v)", row.Value("install", defaultFormatOptions), err)
+	}
+}
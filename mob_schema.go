@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultSchemaFile = "mobvista_schema.json"
+
+// Schema policies for -schema-policy.
+const (
+	schemaWarn   = "warn"
+	schemaFail   = "fail"
+	schemaAppend = "append"
+)
+
+// ErrSchemaDrift is returned when the fail policy sees a schema change.
+var ErrSchemaDrift = errors.New("schema drift")
+
+// schemaDrift is the difference between two field sets.
+type schemaDrift struct {
+	Added       []string          `json:"added,omitempty"`
+	Removed     []string          `json:"removed,omitempty"`
+	TypeChanged map[string]string `json:"type_changed,omitempty"` // field -> "old -> new"
+}
+
+func (d schemaDrift) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.TypeChanged) == 0
+}
+
+func (d schemaDrift) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+	var parts []string
+	if len(d.Added) > 0 {
+		parts = append(parts, "added: "+strings.Join(d.Added, ", "))
+	}
+	if len(d.Removed) > 0 {
+		parts = append(parts, "removed: "+strings.Join(d.Removed, ", "))
+	}
+	if len(d.TypeChanged) > 0 {
+		names := sortedKeys(d.TypeChanged)
+		changes := make([]string, len(names))
+		for i, name := range names {
+			changes[i] = name + " (" + d.TypeChanged[name] + ")"
+		}
+		parts = append(parts, "type changed: "+strings.Join(changes, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// schemaRecord is one entry in the schema history.
+type schemaRecord struct {
+	DetectedAt string `json:"detected_at"`
+	Window     string `json:"window"`
+	schemaDrift
+}
+
+// schemaFile is the persisted view of what the API returned last time,
+// plus every change seen so far.
+type schemaFile struct {
+	Fields     map[string]string `json:"fields"`
+	DetectedAt string            `json:"detected_at"`
+	History    []schemaRecord    `json:"history,omitempty"`
+}
+
+// schemaObserver accumulates the JSON types of fields one row at a time,
+// so a streamed export can check its schema without keeping the rows.
+type schemaObserver map[string]map[string]bool
+
+func (o schemaObserver) observe(row *IAARow) {
+	for name, kind := range row.kinds {
+		if o[name] == nil {
+			o[name] = make(map[string]bool)
+		}
+		o[name][kind] = true
+	}
+}
+
+// schema returns the JSON type of every field seen. A field that arrived
+// with different types gets them all, e.g. "number|string"; nulls only
+// count when nothing else was seen.
+func (o schemaObserver) schema() map[string]string {
+	schema := make(map[string]string, len(o))
+	for name, set := range o {
+		kinds := make(map[string]bool, len(set))
+		for kind := range set {
+			kinds[kind] = true
+		}
+		if len(kinds) > 1 {
+			delete(kinds, "null")
+		}
+		schema[name] = strings.Join(sortedKeys(kinds), "|")
+	}
+	return schema
+}
+
+// observeSchema returns the JSON type of every field seen across rows.
+func observeSchema(rows []*IAARow) map[string]string {
+	observer := make(schemaObserver)
+	for _, row := range rows {
+		observer.observe(row)
+	}
+	return observer.schema()
+}
+
+// detectDrift compares the observed fields with the expected column list
+// and, for types, with the previously persisted schema.
+func detectDrift(expected []string, previous, observed map[string]string) schemaDrift {
+	var d schemaDrift
+	want := make(map[string]bool, len(expected))
+	for _, name := range expected {
+		want[name] = true
+		if _, ok := observed[name]; !ok {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+	for _, name := range sortedKeys(observed) {
+		if !want[name] {
+			d.Added = append(d.Added, name)
+		}
+	}
+	d.TypeChanged = typeChanges(previous, observed)
+	return d
+}
+
+// historyDrift compares two persisted schemas field by field.
+func historyDrift(previous, observed map[string]string) schemaDrift {
+	var d schemaDrift
+	for _, name := range sortedKeys(observed) {
+		if _, ok := previous[name]; !ok {
+			d.Added = append(d.Added, name)
+		}
+	}
+	for _, name := range sortedKeys(previous) {
+		if _, ok := observed[name]; !ok {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+	d.TypeChanged = typeChanges(previous, observed)
+	return d
+}
+
+func typeChanges(previous, observed map[string]string) map[string]string {
+	changed := make(map[string]string)
+	for name, kind := range observed {
+		old, ok := previous[name]
+		if !ok || old == kind || old == "null" || kind == "null" {
+			continue
+		}
+		changed[name] = old + " -> " + kind
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return changed
+}
+
+func loadSchemaFile(path string) (*schemaFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &schemaFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file schemaFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing schema %s: %v", path, err)
+	}
+	return &file, nil
+}
+
+// checkSchema reports drift in rows against the expected columns, records
+// the observed schema at path unless the fail policy rejects it, and
+// applies policy. It returns the columns to export: the expected ones,
+// plus any new fields under the append policy.
+func checkSchema(path, policy string, window DateRange, expected []string, rows []*IAARow) ([]string, error) {
+	return checkObservedSchema(path, policy, window, expected, observeSchema(rows))
+}
+
+// checkObservedSchema is checkSchema for a schema already worked out from
+// the rows. An empty schema means no rows and is not checked.
+func checkObservedSchema(path, policy string, window DateRange, expected []string, observed map[string]string) ([]string, error) {
+	if len(observed) == 0 {
+		return expected, nil
+	}
+	file, err := loadSchemaFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := detectDrift(expected, file.Fields, observed)
+	if !drift.Empty() {
+		fmt.Fprintf(os.Stderr, "Schema drift (%s policy): %s\n", policy, drift)
+	}
+	// A rejected schema is not saved: it would become the baseline and the
+	// next run would pass against it.
+	if policy == schemaFail && !drift.Empty() {
+		return nil, fmt.Errorf("%w: %s", ErrSchemaDrift, drift)
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	if len(file.Fields) > 0 {
+		if change := historyDrift(file.Fields, observed); !change.Empty() {
+			file.History = append(file.History, schemaRecord{DetectedAt: now, Window: window.String(), schemaDrift: change})
+		}
+	}
+	file.Fields = observed
+	file.DetectedAt = now
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(file)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("saving schema %s: %v", path, err)
+	}
+
+	if policy == schemaAppend {
+		return append(append([]string(nil), expected...), drift.Added...), nil
+	}
+	return expected, nil
+}
+
+func validSchemaPolicy(policy string) bool {
+	return policy == schemaWarn || policy == schemaFail || policy == schemaAppend
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func schemaWindow(t *testing.T) DateRange {
+	t.Helper()
+	window, err := resolveDateRange("2025-07-04", "2025-07-05", defaultDays, false, "UTC", time.Date(2025, 7, 20, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return window
+}
+
+func TestCheckSchema_FailPolicyKeepsFailing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), defaultSchemaFile)
+	window := schemaWindow(t)
+	expected := []string{"date", "install"}
+	if _, err := checkObservedSchema(path, schemaFail, window, expected, map[string]string{"date": "string", "install": "number"}); err != nil {
+		t.Fatal(err)
+	}
+
+	drifted := map[string]string{"date": "string", "install": "string"}
+	for run := 1; run <= 2; run++ {
+		if _, err := checkObservedSchema(path, schemaFail, window, expected, drifted); !errors.Is(err, ErrSchemaDrift) {
+			t.Fatalf("Run %d: expected ErrSchemaDrift, got %v", run, err)
+		}
+	}
+	file, err := loadSchemaFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Fields["install"] != "number" || len(file.History) != 0 {
+		t.Errorf("Expected the rejected schema not to be saved, got %v with history %v", file.Fields, file.History)
+	}
+}
+
+func TestDetectDrift(t *testing.T) {
+	expected := []string{"date", "install", "revenue"}
+	previous := map[string]string{"date": "string", "install": "number", "revenue": "number", "ecpm": "null"}
+	cases := []struct {
+		name     string
+		observed map[string]string
+		want     string
+	}{
+		{"same", map[string]string{"date": "string", "install": "number", "revenue": "number"}, "no changes"},
+		{"added", map[string]string{"date": "string", "install": "number", "revenue": "number", "ctr": "number"}, "added: ctr"},
+		{"removed", map[string]string{"date": "string", "install": "number"}, "removed: revenue"},
+		{"type changed", map[string]string{"date": "string", "install": "string", "revenue": "number"}, "type changed: install (number -> string)"},
+		// null says nothing about the type; a field seen for the first time
+		// has nothing to change from
+		{"nulls", map[string]string{"date": "string", "install": "null", "revenue": "number", "ecpm": "number"}, "added: ecpm"},
+		{"all", map[string]string{"date": "number", "install": "number", "ctr": "number"}, "added: ctr; removed: revenue; type changed: date (string -> number)"},
+	}
+	for _, c := range cases {
+		if got := detectDrift(expected, previous, c.observed).String(); got != c.want {
+			t.Errorf("%s: expected %q, got %q", c.name, c.want, got)
+		}
+	}
+}
+
+func TestObserveSchema(t *testing.T) {
+	rows := []*IAARow{
+		decodeRow(t, `{"date":"2025-07-04","install":1,"revenue":null,"package":"com.a"}`),
+		decodeRow(t, `{"date":"2025-07-04","install":"2","revenue":null,"package":null}`),
+	}
+	got := fmt.Sprint(observeSchema(rows))
+	if want := "map[date:string install:number|string package:string revenue:null]"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestCheckSchema_Policies(t *testing.T) {
+	window := schemaWindow(t)
+	expected := []string{"date", "install"}
+	base := map[string]string{"date": "string", "install": "number"}
+	drifted := map[string]string{"date": "string", "install": "number", "ctr": "number"}
+	cases := []struct {
+		policy  string
+		columns string
+		err     error
+	}{
+		{schemaWarn, "[date install]", nil},
+		{schemaAppend, "[date install ctr]", nil},
+		{schemaFail, "[]", ErrSchemaDrift},
+	}
+	for _, c := range cases {
+		path := filepath.Join(t.TempDir(), defaultSchemaFile)
+		if _, err := checkObservedSchema(path, c.policy, window, expected, base); err != nil {
+			t.Fatalf("%s: %v", c.policy, err)
+		}
+		columns, err := checkObservedSchema(path, c.policy, window, expected, drifted)
+		if !errors.Is(err, c.err) || (c.err == nil && err != nil) || fmt.Sprint(columns) != c.columns {
+			t.Errorf("%s: expected %s, %v; got %v, %v", c.policy, c.columns, c.err, columns, err)
+		}
+	}
+
+	// no rows: nothing to check and nothing saved
+	path := filepath.Join(t.TempDir(), defaultSchemaFile)
+	if columns, err := checkObservedSchema(path, schemaFail, window, expected, nil); err != nil || len(columns) != 2 {
+		t.Errorf("Expected an empty schema to pass, got %v, %v", columns, err)
+	}
+	if file, _ := loadSchemaFile(path); file.Fields != nil {
+		t.Errorf("Expected nothing saved for an empty schema, got %v", file.Fields)
+	}
+}
+
+func TestCheckSchema_History(t *testing.T) {
+	path := filepath.Join(t.TempDir(), defaultSchemaFile)
+	window := schemaWindow(t)
+	expected := []string{"date", "install"}
+	runs := []map[string]string{
+		{"date": "string", "install": "number"},
+		{"date": "string", "install": "number"},
+		{"date": "string", "install": "string", "ctr": "number"},
+		{"date": "string", "install": "string"},
+	}
+	for _, observed := range runs {
+		if _, err := checkObservedSchema(path, schemaWarn, window, expected, observed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file, err := loadSchemaFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(file.Fields) != "map[date:string install:string]" || file.DetectedAt == "" {
+		t.Errorf("Expected the last schema saved, got %v at %q", file.Fields, file.DetectedAt)
+	}
+	// the first run sets the baseline and the second changes nothing
+	if len(file.History) != 2 {
+		t.Fatalf("Expected 2 history entries, got %+v", file.History)
+	}
+	if got := file.History[0].String(); got != "added: ctr; type changed: install (number -> string)" {
+		t.Errorf("Unexpected first change %q", got)
+	}
+	if got := file.History[1]; got.String() != "removed: ctr" || got.Window != window.String() {
+		t.Errorf("Unexpected second change %+v", got)
+	}
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServeAddr   = "127.0.0.1:8090"
+	defaultServeReload = 5 * time.Second
+)
+
+// serveFilters are the columns /rows and /aggregate can filter on. Each
+// takes a comma-separated list of values, or the parameter repeated.
+var serveFilters = []string{"package", "offer_id", "channel_id", "account"}
+
+// contentTypes are the response types for ?format=.
+var contentTypes = map[string]string{
+	"json":  "application/json",
+	"csv":   "text/csv; charset=utf-8",
+	"tsv":   "text/tab-separated-values; charset=utf-8",
+	"jsonl": "application/x-ndjson",
+	"html":  "text/html; charset=utf-8",
+}
+
+// datasetFile is one export found in the served directory.
+type datasetFile struct {
+	Name     string `json:"name"`
+	Rows     int    `json:"rows"`
+	Modified string `json:"modified"`
+	Error    string `json:"error,omitempty"` // why the file was left out
+}
+
+type schemaColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // number or string
+}
+
+// dataset is every export in a directory merged into one table. Rows are
+// keyed like the master dataset and where exports overlap the most
+// recently modified file wins, so a restated window replaces the old one.
+type dataset struct {
+	columns []string
+	index   map[string]int
+	types   []string
+	rows    [][]string // in columns order, sorted by masterKeyColumns
+	files   []datasetFile
+	loaded  time.Time
+}
+
+// exportFiles lists the CSV and TSV files in dir, oldest first. Dot files
+// are skipped, which covers the temporaries of writeFileAtomic.
+func exportFiles(dir string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if entry.IsDir() || strings.HasPrefix(name, ".") || (ext != ".csv" && ext != ".tsv") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		files = append(files, info)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if a, b := files[i].ModTime(), files[j].ModTime(); !a.Equal(b) {
+			return a.Before(b)
+		}
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
+}
+
+// filesSignature changes whenever a file is added, removed or rewritten.
+func filesSignature(files []os.FileInfo) string {
+	var b strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&b, "%s\x1f%d\x1f%d\n", f.Name(), f.Size(), f.ModTime().UnixNano())
+	}
+	return b.String()
+}
+
+// loadDataset reads files from dir. A file that cannot be read or has no
+// key columns, such as a report, is listed with its error and left out
+// rather than failing the whole load.
+func loadDataset(dir string, files []os.FileInfo) *dataset {
+	d := &dataset{index: make(map[string]int), files: []datasetFile{}, loaded: time.Now()}
+	merged := make(map[string][]string)
+	for _, info := range files {
+		file := datasetFile{Name: info.Name(), Modified: info.ModTime().Format(time.RFC3339)}
+		t, err := readExportTable(filepath.Join(dir, info.Name()))
+		if err != nil {
+			file.Error = err.Error()
+			d.files = append(d.files, file)
+			continue
+		}
+		for _, column := range t.header {
+			if _, ok := d.index[column]; !ok {
+				d.index[column] = len(d.columns)
+				d.columns = append(d.columns, column)
+			}
+		}
+		for key, record := range t.rows {
+			row := make([]string, len(d.columns))
+			for i, column := range t.header {
+				if i < len(record) {
+					row[d.index[column]] = record[i]
+				}
+			}
+			merged[key] = row
+		}
+		file.Rows = len(t.rows)
+		d.files = append(d.files, file)
+	}
+
+	// rows read before a later file added columns are padded here
+	d.rows = make([][]string, 0, len(merged))
+	for _, row := range merged {
+		for len(row) < len(d.columns) {
+			row = append(row, "")
+		}
+		d.rows = append(d.rows, row)
+	}
+	if len(d.rows) > 0 {
+		keyIndex, _ := columnIndexes(d.columns, masterKeyColumns)
+		sort.Slice(d.rows, func(i, j int) bool {
+			for _, k := range keyIndex {
+				if c := compareCells(d.rows[i][k], d.rows[j][k]); c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+
+	d.types = make([]string, len(d.columns))
+	for i, column := range d.columns {
+		d.types[i] = "number"
+		if textColumns[column] {
+			d.types[i] = "string"
+			continue
+		}
+		for _, row := range d.rows {
+			if row[i] != "" && !jsonNumber.MatchString(row[i]) {
+				d.types[i] = "string"
+				break
+			}
+		}
+	}
+	return d
+}
+
+func (d *dataset) value(row []string, column string) string {
+	if i, ok := d.index[column]; ok {
+		return row[i]
+	}
+	return ""
+}
+
+// rowFilter selects rows by install date and column values.
+type rowFilter struct {
+	start, end string // inclusive YYYY-MM-DD, empty when open
+	values     map[string]map[string]bool
+}
+
+// parseRowFilter reads the filter parameters from q. Any other parameter
+// must be in allowed, so a misspelt filter is an error instead of quietly
+// matching everything.
+func parseRowFilter(q url.Values, allowed ...string) (*rowFilter, error) {
+	f := &rowFilter{start: q.Get("start"), end: q.Get("end"), values: make(map[string]map[string]bool)}
+	for name, values := range q {
+		switch {
+		case name == "start" || name == "end":
+			if len(values) > 1 {
+				return nil, fmt.Errorf("%s given %d times", name, len(values))
+			}
+			if _, err := parseDate(values[0], time.UTC); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		case contains(serveFilters, name):
+			set := make(map[string]bool)
+			for _, v := range values {
+				for _, part := range strings.Split(v, ",") {
+					set[strings.TrimSpace(part)] = true
+				}
+			}
+			f.values[name] = set
+		case !contains(allowed, name):
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	if f.start != "" && f.end != "" && f.start > f.end {
+		return nil, fmt.Errorf("start %s is after end %s", f.start, f.end)
+	}
+	return f, nil
+}
+
+func (d *dataset) filter(f *rowFilter) [][]string {
+	var rows [][]string
+	for _, row := range d.rows {
+		date := d.value(row, "date")
+		if (f.start != "" && date < f.start) || (f.end != "" && date > f.end) {
+			continue
+		}
+		match := true
+		for column, set := range f.values {
+			if !set[d.value(row, column)] {
+				match = false
+				break
+			}
+		}
+		if match {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// jsonTable is the JSON response of /rows and /aggregate.
+type jsonTable struct {
+	Columns []string  `json:"columns"`
+	Total   int       `json:"total"` // matching rows before limit and offset
+	Rows    []jsonRow `json:"rows"`
+}
+
+// jsonRow is a row as an object, keys in column order and values typed
+// like the jsonl export.
+type jsonRow struct {
+	columns, values []string
+}
+
+func (r jsonRow) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, column := range r.columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(column)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(jsonValue(column, r.values[i]))
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// responseFormat is ?format=, or csv when only the Accept header asks
+// for it, otherwise json.
+func responseFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			format = "csv"
+		}
+	}
+	if _, ok := contentTypes[format]; !ok {
+		return "", fmt.Errorf("unknown format %q (want json, %s)", format, formatNames())
+	}
+	return format, nil
+}
+
+func queryInt(q url.Values, name string, fallback int) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s: want a non-negative integer, got %q", name, s)
+	}
+	return n, nil
+}
+
+// queryServer answers queries over the exports in dir, reloading them
+// when the files change.
+type queryServer struct {
+	dir string
+
+	mu   sync.RWMutex
+	data *dataset
+	sig  string
+}
+
+func newQueryServer(dir string) (*queryServer, error) {
+	s := &queryServer{dir: dir}
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *queryServer) current() *dataset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data
+}
+
+// reload rereads the directory if its files changed since the last load.
+// Requests in flight keep the dataset they started with.
+func (s *queryServer) reload() (bool, error) {
+	files, err := exportFiles(s.dir)
+	if err != nil {
+		return false, err
+	}
+	sig := filesSignature(files)
+	s.mu.RLock()
+	unchanged := s.data != nil && sig == s.sig
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	d := loadDataset(s.dir, files)
+	s.mu.Lock()
+	s.data, s.sig = d, sig
+	s.mu.Unlock()
+	return true, nil
+}
+
+// watch polls the directory every interval; stdlib has no file
+// notifications and a poll of a few files costs nothing.
+func (s *queryServer) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		changed, err := s.reload()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Reloading %s failed: %v\n", s.dir, err)
+			continue
+		}
+		if changed {
+			fmt.Println("Reloaded:", s.current().summary())
+		}
+	}
+}
+
+func (d *dataset) summary() string {
+	skipped := 0
+	for _, f := range d.files {
+		if f.Error != "" {
+			skipped++
+		}
+	}
+	return fmt.Sprintf("%d rows from %d files (%d skipped)", len(d.rows), len(d.files)-skipped, skipped)
+}
+
+func (s *queryServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	for path, handle := range map[string]http.HandlerFunc{
+		"/rows":      s.handleRows,
+		"/aggregate": s.handleAggregate,
+		"/schema":    s.handleSchema,
+	} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				w.Header().Set("Allow", "GET, HEAD")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			handle(w, r)
+		})
+	}
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", contentTypes["json"])
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+func writeQueryError(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+}
+
+// writeQueryTable sends rows as JSON, or through the exporter for the other
+// formats.
+func writeQueryTable(w http.ResponseWriter, format, title string, columns []string, total int, rows [][]string) {
+	if format == "json" {
+		table := jsonTable{Columns: columns, Total: total, Rows: make([]jsonRow, len(rows))}
+		for i, row := range rows {
+			table.Rows[i] = jsonRow{columns, row}
+		}
+		writeJSON(w, http.StatusOK, table)
+		return
+	}
+	exporter, err := newExporter(w, ExportOptions{Format: format, Title: title})
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Header().Set("X-Total-Rows", strconv.Itoa(total))
+	if err := exporter.Begin(columns); err != nil {
+		return
+	}
+	for _, row := range rows {
+		if err := exporter.WriteRow(row); err != nil {
+			return
+		}
+	}
+	exporter.End()
+}
+
+// handleRows serves the matching rows, optionally cut to ?columns= and
+// paged with ?limit= and ?offset=.
+func (s *queryServer) handleRows(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format, err := responseFormat(r)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	filter, err := parseRowFilter(q, "format", "columns", "limit", "offset")
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	limit, err := queryInt(q, "limit", 0)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	offset, err := queryInt(q, "offset", 0)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	d := s.current()
+	columns := d.columns
+	if list := splitList(q.Get("columns")); len(list) > 0 {
+		columns = list
+		for _, column := range columns {
+			if _, ok := d.index[column]; !ok {
+				writeQueryError(w, fmt.Errorf("unknown column %q", column))
+				return
+			}
+		}
+	}
+
+	rows := d.filter(filter)
+	total := len(rows)
+	rows = rows[min(offset, len(rows)):]
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	out := make([][]string, len(rows))
+	for i, row := range rows {
+		out[i] = make([]string, len(columns))
+		for j, column := range columns {
+			out[i][j] = d.value(row, column)
+		}
+	}
+	writeQueryTable(w, format, "Mobvista IAA rows", columns, total, out)
+}
+
+// handleAggregate rolls the matching rows up like the report command:
+// ?by=, ?sort=, ?desc=, ?top= and ?decimals= take the same values as its
+// flags.
+func (s *queryServer) handleAggregate(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format, err := responseFormat(r)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	filter, err := parseRowFilter(q, "format", "by", "sort", "desc", "top", "decimals")
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	opts := reportOptions{By: splitList(q.Get("by")), Sort: q.Get("sort")}
+	if len(opts.By) == 0 {
+		opts.By = []string{"package"}
+	}
+	if v := q.Get("desc"); v != "" {
+		if opts.Desc, err = strconv.ParseBool(v); err != nil {
+			writeQueryError(w, fmt.Errorf("desc: want true or false, got %q", v))
+			return
+		}
+	}
+	if opts.Top, err = queryInt(q, "top", 0); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	if opts.Decimals, err = queryInt(q, "decimals", defaultReportDecimals); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	d := s.current()
+	builder := newReportBuilder(opts)
+	if len(d.columns) > 0 {
+		if err := builder.add(d.columns, d.filter(filter)); err != nil {
+			writeQueryError(w, err)
+			return
+		}
+	}
+	rows, err := builder.rows()
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	writeQueryTable(w, format, "Mobvista IAA by "+strings.Join(opts.By, ", "), builder.columns(), len(rows), rows)
+}
+
+// handleSchema describes the loaded data: columns with their types, the
+// date range covered and every file with its row count or error.
+func (s *queryServer) handleSchema(w http.ResponseWriter, r *http.Request) {
+	d := s.current()
+	schema := struct {
+		Columns  []schemaColumn `json:"columns"`
+		Rows     int            `json:"rows"`
+		Start    string         `json:"start,omitempty"`
+		End      string         `json:"end,omitempty"`
+		Filters  []string       `json:"filters"`
+		Files    []datasetFile  `json:"files"`
+		LoadedAt string         `json:"loaded_at"`
+	}{
+		Columns:  make([]schemaColumn, len(d.columns)),
+		Rows:     len(d.rows),
+		Filters:  append([]string{"start", "end"}, serveFilters...),
+		Files:    d.files,
+		LoadedAt: d.loaded.Format(time.RFC3339),
+	}
+	for i, column := range d.columns {
+		schema.Columns[i] = schemaColumn{Name: column, Type: d.types[i]}
+	}
+	if len(d.rows) > 0 {
+		schema.Start = d.value(d.rows[0], "date")
+		schema.End = d.value(d.rows[len(d.rows)-1], "date")
+	}
+	writeJSON(w, http.StatusOK, schema)
+}
+
+// runServe serves the exports in a directory over HTTP until interrupted.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory of CSV/TSV exports to serve")
+	addr := fs.String("addr", defaultServeAddr, "listen address")
+	interval := fs.Duration("reload", defaultServeReload, "how often to check the directory for new or changed exports (0 disables)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s serve [flags]\n\nEndpoints: GET /rows, /aggregate and /schema; ?format=json (default), %s\n", filepath.Base(os.Args[0]), formatNames())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || *interval < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	server, err := newQueryServer(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, f := range server.current().files {
+		if f.Error != "" {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %s\n", f.Name, f.Error)
+		}
+	}
+	if *interval > 0 {
+		go server.watch(*interval)
+	}
+	fmt.Printf("Serving %s in %s on http://%s\n", server.current().summary(), *dir, *addr)
+	if err := http.ListenAndServe(*addr, server.handler()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeServeFile(t *testing.T, path, content string, modified time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getQuery(t *testing.T, server *httptest.Server, path string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestQueryServer(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Date(2025, 7, 10, 0, 0, 0, 0, time.UTC)
+	writeServeFile(t, filepath.Join(dir, "a.csv"), "date,channel_id,offer_id,package,install,revenue_d7\n"+
+		"2025-07-07,1,10,com.a,100,50\n"+
+		"2025-07-08,1,10,com.a,200,60\n"+
+		"2025-07-08,2,20,com.b,40,\n", old)
+	// restates 07-08 for offer 10 and adds a column
+	writeServeFile(t, filepath.Join(dir, "b.tsv"), "date\tchannel_id\toffer_id\tpackage\tinstall\trevenue_d7\trr_d1\n"+
+		"2025-07-08\t1\t10\tcom.a\t250\t70\t0.4\n", old.Add(time.Hour))
+	writeServeFile(t, filepath.Join(dir, "report.csv"), "package,install\ncom.a,1\n", old)
+
+	qs, err := newQueryServer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(qs.handler())
+	defer server.Close()
+
+	status, body := getQuery(t, server, "/rows?start=2025-07-08&package=com.a&columns=date,%20install,rr_d1")
+	want := `{"columns":["date","install","rr_d1"],"total":1,"rows":[{"date":"2025-07-08","install":250,"rr_d1":0.4}]}`
+	if status != http.StatusOK || strings.TrimSpace(body) != want {
+		t.Errorf("Expected the newer file to win:\n%s\ngot %d %s", want, status, body)
+	}
+
+	status, body = getQuery(t, server, "/rows?offer_id=10,20&limit=1&offset=1&columns=date,offer_id&format=csv")
+	if status != http.StatusOK || body != "date,offer_id\n2025-07-08,10\n" {
+		t.Errorf("Unexpected CSV page %d %q", status, body)
+	}
+
+	status, body = getQuery(t, server, "/aggregate?by=package&format=csv&decimals=2")
+	if status != http.StatusOK || !strings.Contains(body, "com.a,2,350,,,120,0.40,,,\n") || !strings.Contains(body, "com.b,1,40,,,,,,,\n") {
+		t.Errorf("Unexpected aggregate %d:\n%s", status, body)
+	}
+	status, body = getQuery(t, server, "/aggregate?by=package,%20date&format=csv")
+	if status != http.StatusOK || !strings.HasPrefix(body, "package,date,") || !strings.Contains(body, "com.a,2025-07-07,1,100,") {
+		t.Errorf("Expected spaces around ?by= entries ignored, got %d:\n%s", status, body)
+	}
+
+	var schema struct {
+		Columns []schemaColumn
+		Rows    int
+		Start   string
+		End     string
+		Files   []datasetFile
+	}
+	_, body = getQuery(t, server, "/schema")
+	if err := json.Unmarshal([]byte(body), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Rows != 3 || schema.Start != "2025-07-07" || schema.End != "2025-07-08" || len(schema.Columns) != 7 {
+		t.Errorf("Unexpected schema %+v", schema)
+	}
+	if len(schema.Files) != 3 || schema.Files[0].Name != "a.csv" || schema.Files[1].Error == "" {
+		t.Errorf("Expected report.csv listed as skipped, got %+v", schema.Files)
+	}
+
+	for _, path := range []string{"/rows?pakage=com.a", "/rows?start=07/08/2025", "/rows?limit=-1", "/aggregate?by=country", "/aggregate?sort=nope", "/rows?format=xml", "/rows?start=2025-07-07&start=2025-07-08", "/aggregate?end=2025-07-08&end=2025-07-09"} {
+		if status, body := getQuery(t, server, path); status != http.StatusBadRequest || !strings.Contains(body, `"error"`) {
+			t.Errorf("%s: expected a 400 with an error, got %d %s", path, status, body)
+		}
+	}
+
+	// a new export shows up on the next reload
+	writeServeFile(t, filepath.Join(dir, "c.csv"), "date,channel_id,offer_id,package,install\n2025-07-09,1,30,com.c,5\n", old.Add(2*time.Hour))
+	if changed, err := qs.reload(); err != nil || !changed {
+		t.Fatalf("Expected a reload, got %v %v", changed, err)
+	}
+	if changed, _ := qs.reload(); changed {
+		t.Error("Expected no reload when nothing changed")
+	}
+	if _, body := getQuery(t, server, "/rows?package=com.c&columns=offer_id"); !strings.Contains(body, `"offer_id":30`) {
+		t.Errorf("Expected the new file served, got %s", body)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Request signing for the Mobvista open API. The token is the hex SHA-256
+// of the URL-encoded query, keys sorted, with client_secret_key included.
+// The secret itself is then dropped and the token sent in its place.
+const (
+	paramClientKey = "client_key"
+	paramSecret    = "client_secret_key"
+	paramTime      = "time"
+	paramToken     = "token"
+)
+
+// Verification errors. Match them with errors.Is.
+var (
+	ErrMissingToken = errors.New("missing token")
+	ErrBadSignature = errors.New("invalid token")
+	ErrClockSkew    = errors.New("request time outside allowed skew")
+	ErrReplay       = errors.New("token already used")
+)
+
+// Signer signs requests for one client key.
+type Signer struct {
+	ClientKey string
+	secret    string
+	Now       func() time.Time // defaults to time.Now; override in tests
+}
+
+func NewSigner(clientKey, secret string) *Signer {
+	return &Signer{ClientKey: clientKey, secret: secret, Now: time.Now}
+}
+
+// Sign returns a copy of params with client_key, a fresh time (unless one
+// is already set) and the token added. params is not modified and the
+// result never contains the secret.
+func (s *Signer) Sign(params url.Values) url.Values {
+	signed := url.Values{}
+	for k, v := range params {
+		if k != paramSecret && k != paramToken {
+			signed[k] = append([]string(nil), v...)
+		}
+	}
+	signed.Set(paramClientKey, s.ClientKey)
+	if signed.Get(paramTime) == "" {
+		now := time.Now
+		if s.Now != nil {
+			now = s.Now
+		}
+		signed.Set(paramTime, strconv.FormatInt(now().Unix(), 10))
+	}
+	signed.Set(paramToken, computeToken(signed, s.secret))
+	return signed
+}
+
+// computeToken hashes every parameter except token, plus the secret.
+// url.Values.Encode sorts by key, which is the ordering the API expects.
+func computeToken(params url.Values, secret string) string {
+	values := url.Values{}
+	for k, v := range params {
+		if k != paramToken {
+			values[k] = v
+		}
+	}
+	values.Set(paramSecret, secret)
+	sum := sha256.Sum256([]byte(values.Encode()))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReplayGuard is consulted after a token verifies. Returning an error
+// rejects the request; implementations decide how long tokens are kept.
+// requestTime is zero when the verifier does not check time.
+type ReplayGuard interface {
+	Check(token string, requestTime time.Time) error
+}
+
+// Verifier checks signed requests on the server side.
+type Verifier struct {
+	Secret  string
+	MaxSkew time.Duration    // zero disables the time check
+	Now     func() time.Time // defaults to time.Now
+	Replay  ReplayGuard      // optional
+}
+
+// Verify checks values with the given secret, rejecting a time parameter
+// more than maxSkew away from now.
+func Verify(values url.Values, secret string, maxSkew time.Duration) error {
+	v := Verifier{Secret: secret, MaxSkew: maxSkew}
+	return v.Verify(values)
+}
+
+func (v *Verifier) Verify(values url.Values) error {
+	token := values.Get(paramToken)
+	if token == "" {
+		return ErrMissingToken
+	}
+	want := computeToken(values, v.Secret)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		return ErrBadSignature
+	}
+
+	// time is only required when it is checked
+	var requestTime time.Time
+	if v.MaxSkew > 0 {
+		ts, err := strconv.ParseInt(values.Get(paramTime), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid time %q", ErrClockSkew, values.Get(paramTime))
+		}
+		requestTime = time.Unix(ts, 0)
+		now := time.Now
+		if v.Now != nil {
+			now = v.Now
+		}
+		skew := now().Sub(requestTime)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > v.MaxSkew {
+			return fmt.Errorf("%w: off by %v, allowed %v", ErrClockSkew, skew.Round(time.Second), v.MaxSkew)
+		}
+	}
+	if v.Replay != nil {
+		return v.Replay.Check(token, requestTime)
+	}
+	return nil
+}
+
+// memoryReplayGuard remembers tokens for ttl and rejects repeats. ttl should
+// be at least twice the verifier's MaxSkew so a token cannot be forgotten
+// while its time is still acceptable.
+type memoryReplayGuard struct {
+	ttl  time.Duration
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newMemoryReplayGuard(ttl time.Duration) *memoryReplayGuard {
+	return &memoryReplayGuard{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+func (g *memoryReplayGuard) Check(token string, requestTime time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	for t, at := range g.seen {
+		if now.Sub(at) > g.ttl {
+			delete(g.seen, t)
+		}
+	}
+	if _, ok := g.seen[token]; ok {
+		return ErrReplay
+	}
+	g.seen[token] = now
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Golden vectors, computed independently of this code, pin the exact
+// string that gets hashed: sorted keys, form encoding, secret included.
+var signerVectors = []struct {
+	name      string
+	clientKey string
+	secret    string
+	params    map[string]string
+	token     string
+}{
+	{
+		name:      "basic page request",
+		clientKey: "10001",
+		secret:    "test-secret",
+		params: map[string]string{
+			"time":       "1720000000",
+			"start_date": "2025-07-04",
+			"end_date":   "2025-07-11",
+			"page":       "1",
+			"per_page":   "300",
+		},
+		token: "f8c242acea66c62c01158d3f278d623ecd0384a2d603b23b94efc46a13b178f5",
+	},
+	{
+		name:      "escaping in params and secret",
+		clientKey: "13669",
+		secret:    "S3CR3T+/=",
+		params: map[string]string{
+			"time":       "1751600000",
+			"start_date": "2025-07-04",
+			"end_date":   "2025-07-04",
+			"page":       "12",
+			"per_page":   "50",
+			"note":       "a b&c/ü",
+		},
+		token: "4c73d9109b6c70059137281e6f95dd15a9c7127703b43774313fe443e028375a",
+	},
+}
+
+func TestSigner_GoldenVectors(t *testing.T) {
+	for _, v := range signerVectors {
+		params := url.Values{}
+		for k, val := range v.params {
+			params.Set(k, val)
+		}
+
+		signed := NewSigner(v.clientKey, v.secret).Sign(params)
+		if got := signed.Get("token"); got != v.token {
+			t.Errorf("%s: expected token %s, got %s", v.name, v.token, got)
+		}
+		if signed.Has("client_secret_key") {
+			t.Errorf("%s: signed values must not contain the secret", v.name)
+		}
+		if params.Has("token") {
+			t.Errorf("%s: Sign must not modify its input", v.name)
+		}
+	}
+}
+
+func TestSigner_FreshTime(t *testing.T) {
+	s := NewSigner("10001", "test-secret")
+	s.Now = func() time.Time { return time.Unix(1720000000, 0) }
+
+	signed := s.Sign(url.Values{"page": {"1"}})
+	if signed.Get("time") != "1720000000" {
+		t.Errorf("Expected time 1720000000, got %s", signed.Get("time"))
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1720000000, 0)
+	s := NewSigner("10001", "test-secret")
+	s.Now = func() time.Time { return now }
+	signed := s.Sign(url.Values{"page": {"1"}})
+
+	v := &Verifier{Secret: "test-secret", MaxSkew: time.Minute, Now: func() time.Time { return now.Add(30 * time.Second) }}
+	if err := v.Verify(signed); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+
+	tampered := url.Values{}
+	for k, val := range signed {
+		tampered[k] = val
+	}
+	tampered.Set("page", "2")
+	if err := v.Verify(tampered); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature for tampered params, got %v", err)
+	}
+
+	if err := Verify(signed, "other-secret", 0); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature for wrong secret, got %v", err)
+	}
+
+	late := &Verifier{Secret: "test-secret", MaxSkew: time.Minute, Now: func() time.Time { return now.Add(2 * time.Minute) }}
+	if err := late.Verify(signed); !errors.Is(err, ErrClockSkew) {
+		t.Errorf("Expected ErrClockSkew, got %v", err)
+	}
+
+	// MaxSkew 0 turns the time check off, so time need not be sent at all
+	untimed := url.Values{"page": {"1"}}
+	untimed.Set(paramToken, computeToken(untimed, "test-secret"))
+	if err := Verify(untimed, "test-secret", 0); err != nil {
+		t.Errorf("Expected no time check with MaxSkew 0, got %v", err)
+	}
+	if err := Verify(untimed, "test-secret", time.Minute); !errors.Is(err, ErrClockSkew) {
+		t.Errorf("Expected a missing time to fail the check, got %v", err)
+	}
+
+	if err := Verify(url.Values{"page": {"1"}}, "test-secret", 0); !errors.Is(err, ErrMissingToken) {
+		t.Errorf("Expected ErrMissingToken, got %v", err)
+	}
+}
+
+func TestVerify_ReplayGuard(t *testing.T) {
+	signed := NewSigner("10001", "test-secret").Sign(url.Values{"page": {"1"}})
+	v := &Verifier{Secret: "test-secret", MaxSkew: time.Minute, Replay: newMemoryReplayGuard(3 * time.Minute)}
+
+	if err := v.Verify(signed); err != nil {
+		t.Fatalf("first use: unexpected error %v", err)
+	}
+	if err := v.Verify(signed); !errors.Is(err, ErrReplay) {
+		t.Errorf("Expected ErrReplay on second use, got %v", err)
+	}
+}
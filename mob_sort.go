@@ -1,26 +1,33 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/csv"
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"io"
-	"net/http"
 	"net/url"
 	"os"
-	"sort"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
 type ApiResponse struct {
-	Status bool `json:"status"`
-	Data   struct {
-		Data []map[string]interface{} `json:"data"`
+	Status  bool   `json:"status"`
+	Msg     string `json:"msg"`
+	Message string `json:"message"`
+	Data    struct {
+		Total int       `json:"total"`
+		Data  []*IAARow `json:"data"`
 	} `json:"data"`
 }
 
-// Fixed field order as specified
+// message returns whichever error message field the API filled in.
+func (r *ApiResponse) message() string {
+	return firstNonEmpty(r.Msg, r.Message)
+}
+
+// fixedFieldOrder is the default column layout (-columns fixed) and the
+// schema the API is expected to return.
 var fixedFieldOrder = []string{
 	"date",
 	"channel_id",
@@ -50,119 +57,370 @@ var fixedFieldOrder = []string{
 	"revenue_d30",
 }
 
-var endDate = time.Now().Format("2006-01-02")
-var startDate = time.Now().AddDate(0, 0, -6).Format("2006-01-02") // 6天前+今天=7天
+const defaultOutputPattern = "Mobvista_IAA_{start}_{end}.{ext}"
 
 func main() {
-	// 1. Prepare the API request URL with authentication
-	url := prepareApiUrl()
-	fmt.Println("Request URL:", url)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backfill":
+			runBackfill(os.Args[2:])
+			return
+		case "mock":
+			runMock(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "diff":
+			runDiff(os.Args[2:])
+			return
+		case "report":
+			runReport(os.Args[2:])
+			return
+		case "project":
+			runProject(os.Args[2:])
+			return
+		case "alert":
+			runAlert(os.Args[2:])
+			return
+		case "dashboard":
+			runDashboard(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		}
+	}
+	runExport(os.Args[1:])
+}
+
+// runExport fetches one date window and writes it out, or upserts it into
+// the master dataset in incremental mode.
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configFlags := registerConfigFlags(fs)
+	dateFlags := registerDateFlags(fs)
+	maturityFlags := registerMaturityFlags(fs)
+	formatOpts := defaultFormatOptions
+	fs.IntVar(&formatOpts.RateDecimals, "rate-decimals", -1, "decimal places for rr_d* columns (-1 keeps the API value)")
+	fs.IntVar(&formatOpts.ROASDecimals, "roas-decimals", -1, "decimal places for d*_roas columns (-1 keeps the API value)")
+	fs.IntVar(&formatOpts.RevenueDecimals, "revenue-decimals", -1, "decimal places for revenue_d* columns (-1 keeps the API value)")
+	exportOpts := ExportOptions{}
+	fs.StringVar(&exportOpts.Format, "format", "csv", "output format: "+formatNames())
+	fs.BoolVar(&exportOpts.BOM, "bom", false, "start CSV/TSV output with a UTF-8 BOM so Excel detects the encoding")
+	outputPattern := fs.String("output-pattern", defaultOutputPattern, "output filename pattern; placeholders: {start} {end} {ext} {format} {profile} {client_key}")
+	incremental := fs.Bool("incremental", false, "upsert into the master dataset instead of writing a new file")
+	stateFile := fs.String("state-file", "", "incremental sync state file (default <output-dir>/"+defaultStateFile+")")
+	masterFile := fs.String("master", "", "incremental master dataset (default <output-dir>/"+defaultMasterFile+")")
+	restateDays := fs.Int("restate-days", defaultRestateDays, "days before the last synced date to fetch again in incremental mode")
+	schemaPolicy := fs.String("schema-policy", schemaWarn, "what to do when API fields differ from the expected columns: warn, fail or append")
+	schemaPath := fs.String("schema-file", "", "where the detected schema is recorded (default <output-dir>/"+defaultSchemaFile+")")
+	columnsFlag := fs.String("columns", "fixed", "column layout: fixed, sorted (every field returned, alphabetically) or file:<path>")
+	qualityMode := fs.String("quality", qualityOff, "data quality rules: off, warn (report only) or strict (fail the export on error-severity violations)")
+	rulesPath := fs.String("rules", "", "quality rule file (default: the built-in rules, see quality_rules.example.json)")
+	qualityReportPath := fs.String("quality-report", "", "where violations are written (default <output-dir>/"+defaultQualityReport+")")
+	stream := fs.Bool("stream", false, "decode pages and write rows as they arrive instead of holding the whole window in memory")
+	accounts := fs.String("accounts", "", "comma-separated profiles to export in parallel, or all; each uses its own credentials")
+	accountQPS := fs.Float64("account-qps", defaultAccountQPS, "requests per second for each account whose profile sets no qps")
+	splitAccounts := fs.Bool("split-accounts", false, "with -accounts, write one file per account instead of one merged file; {profile}_ is prepended to -output-pattern unless it names the account")
+	fs.Parse(args)
 
-	// 2. Make the API request
-	resp, err := http.Get(url)
+	if _, ok := outputFormats[exportOpts.Format]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q (want one of %s)\n", exportOpts.Format, formatNames())
+		os.Exit(2)
+	}
+	if !validSchemaPolicy(*schemaPolicy) {
+		fmt.Fprintf(os.Stderr, "unknown schema policy %q (want warn, fail or append)\n", *schemaPolicy)
+		os.Exit(2)
+	}
+	layout, err := parseColumnLayout(*columnsFlag)
 	if err != nil {
-		panic(fmt.Sprintf("API request failed: %v", err))
+		fmt.Fprintln(os.Stderr, "columns:", err)
+		os.Exit(2)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	var rules []*qualityRule
+	switch *qualityMode {
+	case qualityOff:
+	case qualityWarn, qualityStrict:
+		if rules, err = loadQualityRules(*rulesPath); err != nil {
+			fmt.Fprintln(os.Stderr, "quality rules:", err)
+			os.Exit(2)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown quality mode %q (want off, warn or strict)\n", *qualityMode)
+		os.Exit(2)
+	}
+	// Streaming writes the header before any row is seen, so the columns
+	// must be known up front, and there is no row set to upsert.
+	if *stream && (*incremental || layout.mode == "sorted" || *schemaPolicy == schemaAppend) {
+		fmt.Fprintln(os.Stderr, "-stream cannot be combined with -incremental, -columns sorted or -schema-policy append")
+		os.Exit(2)
+	}
+	if *accounts != "" && (*incremental || *stream || *accountQPS <= 0) {
+		fmt.Fprintln(os.Stderr, "-accounts cannot be combined with -incremental or -stream, and -account-qps must be positive")
+		os.Exit(2)
+	}
+	window, err := dateFlags.resolve(time.Now())
 	if err != nil {
-		panic(fmt.Sprintf("Reading response failed: %v", err))
+		fmt.Fprintln(os.Stderr, "date range:", err)
+		os.Exit(2)
 	}
-
-	// 3. Parse the JSON response
-	var apiResponse ApiResponse
-	err = json.Unmarshal(body, &apiResponse)
+	maturity, err := maturityFlags.resolve(window.Location, time.Now())
 	if err != nil {
-		panic(fmt.Sprintf("JSON parsing failed: %v", err))
+		fmt.Fprintln(os.Stderr, "maturity:", err)
+		os.Exit(2)
 	}
-
-	if !apiResponse.Status {
-		panic("API returned non-success status")
+	// With -accounts the first account stands in for shared settings such
+	// as the output directory of the merged file
+	var cfg *Config
+	var accountCfgs []*Config
+	if *accounts != "" {
+		if accountCfgs, err = configFlags.resolveAccounts(*accounts); err == nil {
+			cfg = accountCfgs[0]
+		}
+	} else {
+		cfg, err = configFlags.resolve()
 	}
-
-	// 4. Export to CSV with fixed field order
-	err = exportToCSVWithFixedOrder(apiResponse.Data.Data, fmt.Sprintf("Mobvista_IAA_%s_%s.csv", startDate, endDate))
 	if err != nil {
-		panic(fmt.Sprintf("CSV export failed: %v", err))
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(2)
 	}
-
-	fmt.Println("Data successfully exported to output.csv with fixed field order")
-}
-
-func prepareApiUrl() string {
-	clientKey := "13669"
-	clientSecretKey := "M7T1EE6E6JTQXL07OUDM"
-	baseURL := "https://open.3s.mobvista.com/channel/iaa/v1"
-
-	params := map[string]string{
-		"time":              fmt.Sprintf("%d", time.Now().Unix()),
-		"client_key":        clientKey,
-		"client_secret_key": clientSecretKey,
-		"start_date":        startDate,
-		"end_date":          endDate,
-		"page":              "1",
-		"per_page":          "300",
+	if accountCfgs == nil {
+		fmt.Println("Using", cfg)
+	}
+	for _, c := range accountCfgs {
+		fmt.Println("Account", c)
 	}
+	if *stream && cfg.Source != sourceMobvista {
+		fmt.Fprintf(os.Stderr, "-stream only supports the %s source\n", sourceMobvista)
+		os.Exit(2)
+	}
+	fmt.Println("Window", window)
 
-	// Sort keys and build query string for token
-	keys := make([]string, 0, len(params))
-	for k := range params {
-		keys = append(keys, k)
+	var state *syncState
+	if *incremental {
+		if *restateDays < 1 {
+			fmt.Fprintln(os.Stderr, "restate-days must be at least 1")
+			os.Exit(2)
+		}
+		*stateFile = firstNonEmpty(*stateFile, filepath.Join(cfg.OutputDir, defaultStateFile))
+		*masterFile = firstNonEmpty(*masterFile, filepath.Join(cfg.OutputDir, defaultMasterFile))
+		if state, err = loadSyncState(*stateFile); err != nil {
+			fmt.Fprintln(os.Stderr, "sync state:", err)
+			os.Exit(1)
+		}
+		if window.Start, err = incrementalStart(state, *restateDays, window.Start); err != nil {
+			fmt.Fprintln(os.Stderr, "sync state:", err)
+			os.Exit(1)
+		}
+		if window.End.Before(window.Start) {
+			fmt.Fprintf(os.Stderr, "incremental start %s is after end %s\n", window.StartDate(), window.EndDate())
+			os.Exit(2)
+		}
+		fmt.Printf("Incremental sync from %s (last synced %q)\n", window.StartDate(), state.LastSyncedDate)
 	}
-	sort.Strings(keys)
 
-	values := url.Values{}
-	for _, k := range keys {
-		values.Add(k, params[k])
+	outputFile := func(c *Config, pattern, profile, clientKey string) (string, error) {
+		name, err := expandPattern(pattern, map[string]string{
+			"start":      window.StartDate(),
+			"end":        window.EndDate(),
+			"ext":        outputFormats[exportOpts.Format],
+			"format":     exportOpts.Format,
+			"profile":    profile,
+			"client_key": clientKey,
+		})
+		if err != nil {
+			return "", err
+		}
+		filename := filepath.Join(c.OutputDir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return "", fmt.Errorf("creating output dir failed: %v", err)
+		}
+		return filename, nil
 	}
-	queryString := values.Encode()
+	var filename string
+	if !*incremental && !*splitAccounts {
+		profile, clientKey := cfg.Profile, cfg.ClientKey
+		if accountCfgs != nil {
+			var profiles, keys []string
+			for _, c := range accountCfgs {
+				profiles, keys = append(profiles, c.Profile), append(keys, c.ClientKey)
+			}
+			profile, clientKey = strings.Join(profiles, "+"), strings.Join(keys, "+")
+		}
+		if filename, err = outputFile(cfg, *outputPattern, profile, clientKey); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	*schemaPath = firstNonEmpty(*schemaPath, filepath.Join(cfg.OutputDir, defaultSchemaFile))
+	*qualityReportPath = firstNonEmpty(*qualityReportPath, filepath.Join(cfg.OutputDir, defaultQualityReport))
 
-	// Generate token
-	token := fmt.Sprintf("%x", sha256.Sum256([]byte(queryString)))
+	// Multi-account: every account is fetched and checked on its own under
+	// its own rate limit. A failed account is reported and left out; the
+	// others are still written
+	if accountCfgs != nil {
+		results := fetchAccounts(context.Background(), accountCfgs, window, *accountQPS)
+		expected := checkAccounts(results, window, accountChecks{
+			SchemaPath:   *schemaPath,
+			SchemaPolicy: *schemaPolicy,
+			Rules:        rules,
+			QualityPath:  *qualityReportPath,
+			QualityMode:  *qualityMode,
+		})
+		rows := succeeded(results)
+		finish := func(err error) {
+			fmt.Println("Accounts:")
+			writeAccountSummary(os.Stdout, results)
+			if err == nil {
+				err = firstAccountError(results)
+			}
+			if err != nil {
+				os.Exit(exitCode(err))
+			}
+		}
+		columns := append([]Column{accountColumn}, maturity.apply(layout.resolve(expected, rows))...)
 
-	// Build final URL
-	delete(params, "client_secret_key")
-	params["token"] = token
+		exportOpts.Title = fmt.Sprintf("Mobvista IAA %s to %s", window.StartDate(), window.EndDate())
+		if *splitAccounts {
+			pattern := *outputPattern
+			if !strings.Contains(pattern, "{profile}") && !strings.Contains(pattern, "{client_key}") {
+				pattern = "{profile}_" + pattern
+			}
+			for _, r := range results {
+				if r.err != nil {
+					continue
+				}
+				file, err := outputFile(r.cfg, pattern, r.cfg.Profile, r.cfg.ClientKey)
+				if err == nil {
+					err = exportFile(file, columns, r.rows, formatOpts, exportOpts)
+				}
+				if err != nil {
+					r.err = fmt.Errorf("export failed: %w", err)
+					continue
+				}
+				r.file = file
+			}
+		} else if len(succeededAccounts(results)) > 0 {
+			// With every account failed the previous file is left alone
+			if err := exportFile(filename, columns, rows, formatOpts, exportOpts); err != nil {
+				fmt.Fprintln(os.Stderr, "export failed:", err)
+				finish(err)
+			}
+			for _, r := range results {
+				if r.err == nil {
+					r.file = filename
+				}
+			}
+		}
+		finish(nil)
+		return
+	}
 
-	finalValues := url.Values{}
-	for k, v := range params {
-		finalValues.Add(k, v)
+	var quality *qualityChecker
+	if rules != nil {
+		quality = newQualityChecker(rules, window)
 	}
 
-	return fmt.Sprintf("%s?%s", baseURL, finalValues.Encode())
-}
+	// Streaming: rows go from the response body to the output file one at
+	// a time; the file is only published once every page and the schema
+	// check have passed
+	if *stream {
+		exportOpts.Title = fmt.Sprintf("Mobvista IAA %s to %s", window.StartDate(), window.EndDate())
+		var inspect func(*IAARow)
+		if quality != nil {
+			inspect = quality.inspect
+		}
+		check := func(observed map[string]string) error {
+			if _, err := checkObservedSchema(*schemaPath, *schemaPolicy, window, fixedFieldOrder, observed); err != nil {
+				return err
+			}
+			if quality == nil {
+				return nil
+			}
+			fmt.Println("Quality:", quality)
+			return quality.finish(*qualityReportPath, *qualityMode)
+		}
+		count, err := streamExport(context.Background(), newAPIClient(cfg), window, filename, maturity.apply(layout.resolve(fixedFieldOrder, nil)), formatOpts, exportOpts, inspect, check)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "streaming export failed:", err)
+			os.Exit(exitCode(err))
+		}
+		fmt.Printf("%d rows streamed to %s with %s columns\n", count, filename, layout)
+		return
+	}
 
-func exportToCSVWithFixedOrder(data []map[string]interface{}, filename string) error {
-	file, err := os.Create(filename)
+	// 1-3. Fetch the window from the profile's source; for Mobvista every
+	// page is a request signed on its own
+	rows, err := newSource(cfg, nil).Fetch(context.Background(), window)
 	if err != nil {
-		return err
+		fmt.Fprintln(os.Stderr, "fetch failed:", err)
+		os.Exit(exitCode(err))
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	// Compare what the API sent with the columns we expect to write
+	expected, err := checkSchema(*schemaPath, *schemaPolicy, window, fixedFieldOrder, rows)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "schema check failed:", err)
+		os.Exit(exitCode(err))
+	}
+	columns := maturity.apply(layout.resolve(expected, rows))
 
-	// Write headers in fixed order
-	if err := writer.Write(fixedFieldOrder); err != nil {
-		return err
+	// Run the quality rules before anything is written, so strict mode
+	// leaves the previous export or master untouched
+	if quality != nil {
+		for _, row := range rows {
+			quality.inspect(row)
+		}
+		fmt.Println("Quality:", quality)
+		if err := quality.finish(*qualityReportPath, *qualityMode); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
 	}
 
-	// Write data rows with fields in fixed order
-	for _, record := range data {
-		row := make([]string, len(fixedFieldOrder))
-		for i, field := range fixedFieldOrder {
-			val := record[field]
-			if val == nil {
-				row[i] = ""
-			} else {
-				row[i] = fmt.Sprintf("%v", val)
-			}
+	// 4a. Incremental mode: upsert into the master dataset and move the
+	// state forward only once the master has been written
+	if *incremental {
+		summary, err := upsertMaster(*masterFile, columns, rows, formatOpts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "upsert failed:", err)
+			os.Exit(1)
 		}
-		if err := writer.Write(row); err != nil {
-			return err
+		state.LastSyncedDate = window.EndDate()
+		if err := saveSyncState(*stateFile, state); err != nil {
+			fmt.Fprintln(os.Stderr, "saving sync state failed:", err)
+			os.Exit(1)
 		}
+		fmt.Printf("Synced %s into %s: %s\n", window, *masterFile, summary)
+		return
 	}
 
-	return nil
+	// 4. Export with the chosen column layout in the requested format
+	exportOpts.Title = fmt.Sprintf("Mobvista IAA %s to %s", window.StartDate(), window.EndDate())
+	err = exportFile(filename, columns, rows, formatOpts, exportOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export failed:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%d rows successfully exported to %s with %s columns\n", len(rows), filename, layout)
+}
+
+// prepareApiUrl builds the unsigned report URL for one page; the client's
+// auth strategy adds client_key, time and token when the request is made.
+// A query string already on base_url is kept, with the page parameters
+// replacing any of the same name.
+func prepareApiUrl(cfg *Config, window DateRange, page, perPage int) (string, error) {
+	u, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	params := u.Query()
+	params.Set("start_date", window.StartDate())
+	params.Set("end_date", window.EndDate())
+	params.Set("page", fmt.Sprintf("%d", page))
+	params.Set("per_page", fmt.Sprintf("%d", perPage))
+	u.RawQuery = params.Encode()
+	return u.String(), nil
 }
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Source adapters for -config profiles ("source").
+const (
+	sourceMobvista   = "mobvista"    // signed Mobvista IAA report, paged
+	sourceBearerJSON = "bearer_json" // bearer token, one JSON array per window
+)
+
+// Source is an ad network report. Fetch returns every row for window,
+// mapped into the IAARow schema, so export, diff and report work the same
+// for every network.
+type Source interface {
+	Fetch(ctx context.Context, window DateRange) ([]*IAARow, error)
+}
+
+// authStrategy adds credentials to a request just before it is sent.
+type authStrategy interface {
+	authorize(req *http.Request)
+}
+
+// signedQueryAuth is the Mobvista scheme: client_key, a fresh time and the
+// token derived from the secret are added to the query.
+type signedQueryAuth struct {
+	signer *Signer
+}
+
+func (a signedQueryAuth) authorize(req *http.Request) {
+	req.URL.RawQuery = a.signer.Sign(req.URL.Query()).Encode()
+}
+
+// bearerAuth sends the secret as an Authorization bearer token.
+type bearerAuth struct {
+	token string
+}
+
+func (a bearerAuth) authorize(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+}
+
+// newSource returns the adapter cfg asks for. limiter may be nil or shared
+// between sources.
+func newSource(cfg *Config, limiter *rateLimiter) Source {
+	client := newAPIClient(cfg)
+	client.limiter = limiter
+	if cfg.Source == sourceBearerJSON {
+		client.auth = bearerAuth{cfg.Secret()}
+		return &bearerJSONSource{client: client, spec: cfg.BearerJSON.withDefaults()}
+	}
+	return &mobvistaSource{client: client}
+}
+
+// mobvistaSource is the IAA report. Its field names are the normalized
+// schema, so no mapping is needed.
+type mobvistaSource struct {
+	client *apiClient
+}
+
+func (s *mobvistaSource) Fetch(ctx context.Context, window DateRange) ([]*IAARow, error) {
+	return fetchAllPages(ctx, s.client, window)
+}
+
+// bearerJSONSpec describes a generic API that takes a date range as query
+// parameters and answers with a JSON array of row objects.
+type bearerJSONSpec struct {
+	StartParam string            `json:"start_param,omitempty"` // default start_date
+	EndParam   string            `json:"end_param,omitempty"`   // default end_date
+	DateFormat string            `json:"date_format,omitempty"` // Go layout for dates sent and received, default 2006-01-02
+	Query      map[string]string `json:"query,omitempty"`       // extra fixed query parameters
+	RowsPath   string            `json:"rows_path,omitempty"`   // dot-separated path to the array, empty when the body is the array
+	Fields     fieldMapping      `json:"fields,omitempty"`      // normalized name -> source field
+}
+
+func (s *bearerJSONSpec) withDefaults() bearerJSONSpec {
+	spec := bearerJSONSpec{}
+	if s != nil {
+		spec = *s
+	}
+	spec.StartParam = firstNonEmpty(spec.StartParam, "start_date")
+	spec.EndParam = firstNonEmpty(spec.EndParam, "end_date")
+	spec.DateFormat = firstNonEmpty(spec.DateFormat, dateLayout)
+	return spec
+}
+
+// validate checks the mapping targets against the normalized schema.
+func (s *bearerJSONSpec) validate() error {
+	if s == nil {
+		return nil
+	}
+	for name, field := range s.Fields {
+		if !contains(fixedFieldOrder, name) {
+			return fmt.Errorf("fields: unknown column %q", name)
+		}
+		if field == "" {
+			return fmt.Errorf("fields: no source field for %q", name)
+		}
+	}
+	return nil
+}
+
+// fieldMapping renames source fields to normalized column names. Source
+// fields that are not mapped keep their name, so a source that already
+// uses a normalized name needs no entry, and anything else ends up in
+// Extras.
+type fieldMapping map[string]string
+
+func (m fieldMapping) apply(raw map[string]json.RawMessage) map[string]json.RawMessage {
+	if len(m) == 0 {
+		return raw
+	}
+	mapped := make(map[string]json.RawMessage, len(raw))
+	renamed := make(map[string]bool, len(m))
+	for name, field := range m {
+		if v, ok := raw[field]; ok {
+			mapped[name] = v
+			renamed[field] = true
+		}
+	}
+	for field, v := range raw {
+		if _, taken := mapped[field]; !taken && !renamed[field] {
+			mapped[field] = v
+		}
+	}
+	return mapped
+}
+
+// bearerJSONSource fetches a whole window in one request.
+type bearerJSONSource struct {
+	client *apiClient
+	spec   bearerJSONSpec
+}
+
+func (s *bearerJSONSource) Fetch(ctx context.Context, window DateRange) ([]*IAARow, error) {
+	var rows []*IAARow
+	err := s.client.retry(ctx, 1, func() *APIError {
+		var err *APIError
+		rows, err = s.do(ctx, window)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (s *bearerJSONSource) do(ctx context.Context, window DateRange) ([]*IAARow, *APIError) {
+	if err := s.client.limiter.Wait(ctx); err != nil {
+		return nil, &APIError{Kind: ErrNetwork, Page: 1, Err: err}
+	}
+	// base_url may carry a query string of its own; the spec's parameters
+	// and the dates are added to it, replacing any of the same name.
+	u, err := url.Parse(s.client.cfg.BaseURL)
+	if err != nil {
+		return nil, &APIError{Kind: ErrRejected, Page: 1, Err: err}
+	}
+	params := u.Query()
+	for k, v := range s.spec.Query {
+		params.Set(k, v)
+	}
+	params.Set(s.spec.StartParam, window.Start.Format(s.spec.DateFormat))
+	params.Set(s.spec.EndParam, window.End.Format(s.spec.DateFormat))
+	u.RawQuery = params.Encode()
+	req, err := s.client.newRequest(ctx, u.String())
+	if err != nil {
+		return nil, &APIError{Kind: ErrRejected, Page: 1, Err: err}
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.httpClient.Do(req)
+	if err != nil {
+		return nil, &APIError{Kind: transportErrorKind(err), Page: 1, Err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &APIError{Kind: ErrNetwork, Page: 1, StatusCode: resp.StatusCode, Err: err}
+	}
+	if kind := kindForStatus(resp.StatusCode); kind != nil {
+		return nil, &APIError{
+			Kind:       kind,
+			Page:       1,
+			StatusCode: resp.StatusCode,
+			Message:    snippet(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	rows, err := s.decode(body, window.Location)
+	if err != nil {
+		return nil, &APIError{Kind: ErrDecode, Page: 1, StatusCode: resp.StatusCode, Message: snippet(body), Err: err}
+	}
+	return rows, nil
+}
+
+// decode finds the row array at RowsPath, maps each object and rewrites
+// the date into the normalized YYYY-MM-DD form.
+func (s *bearerJSONSource) decode(body []byte, loc *time.Location) ([]*IAARow, error) {
+	data := json.RawMessage(body)
+	if s.spec.RowsPath != "" {
+		for _, key := range strings.Split(s.spec.RowsPath, ".") {
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(data, &obj); err != nil {
+				return nil, fmt.Errorf("rows_path %s: %v", s.spec.RowsPath, err)
+			}
+			var ok bool
+			if data, ok = obj[key]; !ok {
+				return nil, fmt.Errorf("rows_path %s: no %q", s.spec.RowsPath, key)
+			}
+		}
+	}
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, err
+	}
+
+	rows := make([]*IAARow, 0, len(objects))
+	for i, raw := range objects {
+		row := &IAARow{}
+		if err := row.setFields(s.spec.Fields.apply(raw)); err != nil {
+			return nil, fmt.Errorf("row %d: %v", i, err)
+		}
+		if row.Date != "" && s.spec.DateFormat != dateLayout {
+			date, err := time.ParseInLocation(s.spec.DateFormat, string(row.Date), loc)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: date %q does not match %s", i, row.Date, s.spec.DateFormat)
+			}
+			row.Date = Text(date.Format(dateLayout))
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newBearerSource(t *testing.T, handler http.HandlerFunc, spec *bearerJSONSpec) (Source, DateRange) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	cfg := &Config{
+		Source:     sourceBearerJSON,
+		BaseURL:    server.URL + "/report?account=42&to=stale",
+		Timeout:    2 * time.Second,
+		BearerJSON: spec,
+		secret:     "tok",
+	}
+	window, err := resolveDateRange("2025-07-04", "2025-07-05", defaultDays, false, "UTC", time.Date(2025, 7, 20, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSource(cfg, nil), window
+}
+
+func TestBearerJSONSource(t *testing.T) {
+	spec := &bearerJSONSpec{
+		StartParam: "from",
+		EndParam:   "to",
+		DateFormat: "01/02/2006",
+		Query:      map[string]string{"group_by": "day"},
+		RowsPath:   "result.rows",
+		Fields:     fieldMapping{"date": "day", "offer_id": "campaign", "install": "installs"},
+	}
+	source, window := newBearerSource(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.Header.Get("Authorization") != "Bearer tok" || q.Get("from") != "07/04/2025" || q.Get("to") != "07/05/2025" || q.Get("group_by") != "day" || q.Get("account") != "42" || len(q["to"]) != 1 {
+			t.Errorf("Unexpected request %s with %q", r.URL, r.Header.Get("Authorization"))
+		}
+		w.Write([]byte(`{"result": {"rows": [
+			{"day": "07/04/2025", "campaign": 7, "installs": "12", "impressions": 300, "country": "DE"},
+			{"day": "07/05/2025", "campaign": 7, "installs": null}
+		]}}`))
+	}, spec)
+
+	rows, err := source.Fetch(context.Background(), window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+	row := rows[0]
+	got := []string{row.Value("date", defaultFormatOptions), row.Value("offer_id", defaultFormatOptions), row.Value("install", defaultFormatOptions), row.Value("impressions", defaultFormatOptions)}
+	want := []string{"2025-07-04", "7", "12", "300"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, got)
+			break
+		}
+	}
+	if string(row.Extras["country"]) != `"DE"` || row.Extras["installs"] != nil {
+		t.Errorf("Expected unmapped country kept and mapped installs consumed, got extras %v", row.ExtraNames())
+	}
+	if rows[1].Value("install", defaultFormatOptions) != "" {
+		t.Errorf("Expected null installs to stay blank")
+	}
+}
+
+func TestBearerJSONSource_Errors(t *testing.T) {
+	source, window := newBearerSource(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad token", http.StatusUnauthorized)
+	}, nil)
+	if _, err := source.Fetch(context.Background(), window); !errors.Is(err, ErrAuth) {
+		t.Errorf("Expected ErrAuth, got %v", err)
+	}
+
+	source, window = newBearerSource(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"rows": []}`))
+	}, &bearerJSONSpec{RowsPath: "data"})
+	if _, err := source.Fetch(context.Background(), window); !errors.Is(err, ErrDecode) {
+		t.Errorf("Expected ErrDecode for a missing rows_path, got %v", err)
+	}
+
+	if err := (&bearerJSONSpec{Fields: fieldMapping{"installs": "n"}}).validate(); err == nil {
+		t.Error("Expected a mapping onto an unknown column to be rejected")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// errorBodyLimit caps how much of a non-2xx body is read for its message.
+const errorBodyLimit = 64 << 10
+
+// Streaming export. Instead of reading a page into memory and unmarshalling
+// it whole, the response is tokenized with json.Decoder and each row of
+// data.data is handed to the exporter as soon as it is decoded, so memory
+// stays flat however many rows a window has. Pages are fetched one after
+// the other to keep the output in page order without buffering.
+
+// decodeStream reads one API response from r, calling emit for every row
+// in data.data. It returns the envelope (status, message, total) with no
+// rows in it. If status false arrives before the rows, decoding stops there
+// and nothing is emitted; if it arrives after them, the caller must still
+// treat the page as failed.
+func decodeStream(r io.Reader, emit func(*IAARow) error) (*ApiResponse, error) {
+	dec := json.NewDecoder(r)
+	var head ApiResponse
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	statusSeen := false
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "status":
+			err = dec.Decode(&head.Status)
+			statusSeen = true
+		case "msg":
+			err = dec.Decode(&head.Msg)
+		case "message":
+			err = dec.Decode(&head.Message)
+		case "data":
+			if statusSeen && !head.Status {
+				return &head, nil
+			}
+			err = decodeStreamData(dec, &head, emit)
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	return &head, nil
+}
+
+// decodeStreamData walks the "data" object: {"total": n, "data": [rows]}.
+func decodeStreamData(dec *json.Decoder, head *ApiResponse, emit func(*IAARow) error) error {
+	tok, err := dec.Token()
+	if err != nil || tok == nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("data: expected object, got %v", tok)
+	}
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "total":
+			if err := dec.Decode(&head.Data.Total); err != nil {
+				return err
+			}
+		case "data":
+			if tok, err = dec.Token(); err != nil {
+				return err
+			}
+			if tok == nil {
+				continue
+			}
+			if tok != json.Delim('[') {
+				return fmt.Errorf("data.data: expected array, got %v", tok)
+			}
+			for dec.More() {
+				row := new(IAARow)
+				if err := dec.Decode(row); err != nil {
+					return err
+				}
+				if err := emit(row); err != nil {
+					return err
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return err
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != want {
+		return fmt.Errorf("expected %v, got %v", want, tok)
+	}
+	return nil
+}
+
+func objectKey(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("expected object key, got %v", tok)
+	}
+	return key, nil
+}
+
+// streamPage requests one page and emits its rows as they are decoded. It
+// returns the reported total and how many rows were emitted. Failures are
+// retried like fetchPage, but only while nothing has been emitted: once
+// rows of a page are written a retry would duplicate them. An error from
+// emit is returned as is.
+func (c *apiClient) streamPage(ctx context.Context, window DateRange, page int, emit func(*IAARow) error) (int, int, error) {
+	var lastErr *APIError
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			delay := c.backoff(attempt, lastErr.RetryAfter)
+			fmt.Printf("Retrying page %d in %v (%v)\n", page, delay.Round(time.Millisecond), lastErr.Kind)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return 0, 0, ctx.Err()
+			}
+		}
+
+		total, emitted, err := c.doStream(ctx, window, page, emit)
+		if err == nil {
+			return total, emitted, nil
+		}
+		if !errors.As(err, &lastErr) {
+			return 0, emitted, err
+		}
+		lastErr.Attempts = attempt + 1
+		if emitted > 0 {
+			note := fmt.Sprintf("not retried, %d rows already written", emitted)
+			if lastErr.Message != "" {
+				note = lastErr.Message + "; " + note
+			}
+			lastErr.Message = note
+			break
+		}
+		if !lastErr.retryable() || ctx.Err() != nil {
+			break
+		}
+	}
+	return 0, 0, lastErr
+}
+
+// doStream makes one streaming request. API failures come back as
+// *APIError; anything else is an error from emit.
+func (c *apiClient) doStream(ctx context.Context, window DateRange, page int, emit func(*IAARow) error) (int, int, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return 0, 0, &APIError{Kind: ErrNetwork, Page: page, Err: err}
+	}
+	apiURL, err := prepareApiUrl(c.cfg, window, page, c.cfg.PerPage)
+	if err != nil {
+		return 0, 0, &APIError{Kind: ErrRejected, Page: page, Err: err}
+	}
+	req, err := c.newRequest(ctx, apiURL)
+	if err != nil {
+		return 0, 0, &APIError{Kind: ErrRejected, Page: page, Err: err}
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, 0, &APIError{Kind: transportErrorKind(err), Page: page, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if kind := kindForStatus(resp.StatusCode); kind != nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+		var apiResponse ApiResponse
+		json.Unmarshal(body, &apiResponse)
+		return 0, 0, &APIError{
+			Kind:       kind,
+			Page:       page,
+			StatusCode: resp.StatusCode,
+			Message:    firstNonEmpty(apiResponse.message(), snippet(body)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	emitted := 0
+	var writeErr error
+	body := &readRecorder{r: resp.Body}
+	head, err := decodeStream(body, func(row *IAARow) error {
+		if writeErr = emit(row); writeErr != nil {
+			return writeErr
+		}
+		emitted++
+		return nil
+	})
+	if writeErr != nil {
+		return 0, emitted, writeErr
+	}
+	if err != nil {
+		// A body cut off or garbled by the server is a decode error; a
+		// read that failed underneath the decoder (timeout, reset) is not.
+		kind := ErrDecode
+		if body.err != nil && body.err != io.EOF {
+			kind = ErrNetwork
+		}
+		return 0, emitted, &APIError{Kind: kind, Page: page, StatusCode: resp.StatusCode, Err: err}
+	}
+	if !head.Status {
+		kind := ErrRejected
+		if looksLikeAuthFailure(head.message()) {
+			kind = ErrAuth
+		}
+		return 0, emitted, &APIError{Kind: kind, Page: page, StatusCode: resp.StatusCode, Message: head.message()}
+	}
+	return head.Data.Total, emitted, nil
+}
+
+// readRecorder remembers the last error from the underlying reader.
+type readRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// streamAllPages walks the pages of window in order, emitting rows as they
+// arrive. It stops at the first empty page or once the reported total has
+// been emitted, and returns the number of rows.
+func streamAllPages(ctx context.Context, client *apiClient, window DateRange, emit func(*IAARow) error) (int, error) {
+	count := 0
+	for page := 1; ; page++ {
+		total, n, err := client.streamPage(ctx, window, page, emit)
+		if err != nil {
+			return count, err
+		}
+		count += n
+		if n == 0 || (total > 0 && count >= total) {
+			return count, nil
+		}
+	}
+}
+
+// streamExport writes window straight from the API to filename. inspect,
+// if set, sees every row before it is written. The file only appears once
+// every page decoded with status true and check, given the schema observed
+// along the way, has passed; any failure leaves the previous file, if any,
+// in place.
+func streamExport(ctx context.Context, client *apiClient, window DateRange, filename string, columns []Column, formatOpts FormatOptions, opts ExportOptions, inspect func(*IAARow), check func(observed map[string]string) error) (int, error) {
+	count := 0
+	err := writeFileAtomic(filename, func(w io.Writer) error {
+		exporter, err := newExporter(w, opts)
+		if err != nil {
+			return err
+		}
+		if err := exporter.Begin(columnNames(columns)); err != nil {
+			return err
+		}
+		observer := make(schemaObserver)
+		values := make([]string, len(columns))
+		count, err = streamAllPages(ctx, client, window, func(row *IAARow) error {
+			observer.observe(row)
+			if inspect != nil {
+				inspect(row)
+			}
+			for i, column := range columns {
+				values[i] = column.Value(row, formatOpts)
+			}
+			return exporter.WriteRow(values)
+		})
+		if err != nil {
+			return err
+		}
+		if err := check(observer.schema()); err != nil {
+			return err
+		}
+		return exporter.End()
+	})
+	return count, err
+}
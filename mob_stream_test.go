@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeStream(t *testing.T) {
+	body := `{"code":0,"data":{"extra":[1,2],"total":2,"data":[{"date":"2025-07-04","install":"3"},{"date":"2025-07-05","install":4}]},"status":true}`
+	var dates []string
+	head, err := decodeStream(strings.NewReader(body), func(row *IAARow) error {
+		dates = append(dates, row.Value("date", defaultFormatOptions))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if !head.Status || head.Data.Total != 2 || head.Data.Data != nil {
+		t.Errorf("Expected envelope status=true total=2 and no rows, got %+v", head)
+	}
+	if strings.Join(dates, ",") != "2025-07-04,2025-07-05" {
+		t.Errorf("Expected rows in order, got %v", dates)
+	}
+}
+
+func TestDecodeStream_StatusFirstStopsBeforeRows(t *testing.T) {
+	body := `{"status":false,"msg":"invalid token","data":{"data":[{"date":"2025-07-04"}]}}`
+	head, err := decodeStream(strings.NewReader(body), func(*IAARow) error {
+		t.Error("no row should be emitted after status false")
+		return nil
+	})
+	if err != nil || head.Status || head.message() != "invalid token" {
+		t.Errorf("Expected rejected envelope, got %+v, %v", head, err)
+	}
+}
+
+func newStreamFixture(t *testing.T, faults mockFaults, secret string) (*apiClient, DateRange, string) {
+	client, window := newMockClient(t, faults, secret)
+	return client, window, filepath.Join(t.TempDir(), "out.csv")
+}
+
+func noSchemaCheck(map[string]string) error { return nil }
+
+func TestStreamExport(t *testing.T) {
+	client, window, filename := newStreamFixture(t, mockFaults{}, "mock-secret")
+
+	count, err := streamExport(context.Background(), client, window, filename, fieldColumns(fixedFieldOrder), defaultFormatOptions, ExportOptions{Format: "csv"}, nil, noSchemaCheck)
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	if count != 56 {
+		t.Errorf("Expected 56 rows, got %d", count)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 57 || !strings.HasPrefix(lines[1], "2025-07-04,") || !strings.HasPrefix(lines[56], "2025-07-11,") {
+		t.Errorf("Expected header plus 56 ordered rows, got %d lines", len(lines))
+	}
+}
+
+func TestStreamExport_FailureLeavesNoFile(t *testing.T) {
+	cases := []struct {
+		name   string
+		faults mockFaults
+		secret string
+		check  func(map[string]string) error
+		want   error
+	}{
+		{"wrong secret", mockFaults{}, "not-the-secret", noSchemaCheck, ErrAuth},
+		{"malformed", mockFaults{Malformed: 1}, "mock-secret", noSchemaCheck, ErrDecode},
+		{"schema check", mockFaults{}, "mock-secret", func(map[string]string) error { return ErrSchemaDrift }, ErrSchemaDrift},
+	}
+	for _, c := range cases {
+		client, window, filename := newStreamFixture(t, c.faults, c.secret)
+		_, err := streamExport(context.Background(), client, window, filename, fieldColumns(fixedFieldOrder), defaultFormatOptions, ExportOptions{Format: "csv"}, nil, c.check)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, err)
+		}
+		if entries, _ := os.ReadDir(filepath.Dir(filename)); len(entries) != 0 {
+			t.Errorf("%s: expected no file to be published, found %d entries", c.name, len(entries))
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStateFile   = "mobvista_sync_state.json"
+	defaultMasterFile  = "Mobvista_IAA_master.csv"
+	defaultRestateDays = 7
+)
+
+// masterKeyColumns identify a row in the master dataset.
+var masterKeyColumns = []string{"date", "channel_id", "offer_id"}
+
+// syncState is persisted between incremental runs.
+type syncState struct {
+	LastSyncedDate string `json:"last_synced_date"`
+	UpdatedAt      string `json:"updated_at"`
+}
+
+func loadSyncState(path string) (*syncState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &syncState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state syncState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parsing sync state %s: %v", path, err)
+	}
+	return &state, nil
+}
+
+func saveSyncState(path string, state *syncState) error {
+	state.UpdatedAt = time.Now().Format(time.RFC3339)
+	return writeFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(state)
+	})
+}
+
+// incrementalStart returns the first date to fetch: restateDays before the
+// last synced date so revised days are picked up again, or fallback when
+// nothing has been synced yet.
+func incrementalStart(state *syncState, restateDays int, fallback time.Time) (time.Time, error) {
+	if state.LastSyncedDate == "" {
+		return fallback, nil
+	}
+	last, err := parseDate(state.LastSyncedDate, fallback.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("sync state last_synced_date: %v", err)
+	}
+	return last.AddDate(0, 0, -restateDays+1), nil
+}
+
+type upsertSummary struct {
+	Inserted  int
+	Updated   int
+	Unchanged int
+	Total     int
+}
+
+func (s upsertSummary) String() string {
+	return fmt.Sprintf("inserted=%d updated=%d unchanged=%d total=%d", s.Inserted, s.Updated, s.Unchanged, s.Total)
+}
+
+// upsertMaster merges rows into the master CSV at path, keyed by
+// masterKeyColumns. A row whose values differ from the stored one replaces
+// it. Columns missing from an existing master are added at the end. The
+// file is rewritten sorted by key and swapped in atomically.
+func upsertMaster(path string, layout []Column, rows []*IAARow, opts FormatOptions) (upsertSummary, error) {
+	var summary upsertSummary
+
+	header, records, err := readMaster(path)
+	if err != nil {
+		return summary, err
+	}
+	columns := columnNames(layout)
+	if header != nil {
+		header = append([]string(nil), header...)
+		for _, column := range columns {
+			if !contains(header, column) {
+				header = append(header, column)
+			}
+		}
+		for i, record := range records {
+			for len(record) < len(header) {
+				record = append(record, "")
+			}
+			records[i] = record
+		}
+		columns = header
+	}
+	keyIndex, err := columnIndexes(columns, masterKeyColumns)
+	if err != nil {
+		return summary, fmt.Errorf("master %s: %v", path, err)
+	}
+
+	// Columns the master has but the current layout does not are filled
+	// from the field of the same name, as before the layout was chosen.
+	byName := make(map[string]Column, len(layout))
+	for _, column := range layout {
+		byName[column.Name] = column
+	}
+	valueColumns := make([]Column, len(columns))
+	for i, name := range columns {
+		column, ok := byName[name]
+		if !ok {
+			column = fieldColumn(name, name)
+		}
+		valueColumns[i] = column
+	}
+
+	byKey := make(map[string][]string, len(records))
+	for _, record := range records {
+		byKey[recordKey(record, keyIndex)] = record
+	}
+
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, column := range valueColumns {
+			record[i] = column.Value(row, opts)
+		}
+		key := recordKey(record, keyIndex)
+		existing, ok := byKey[key]
+		switch {
+		case !ok:
+			summary.Inserted++
+		case equalRecords(existing, record):
+			summary.Unchanged++
+			continue
+		default:
+			summary.Updated++
+		}
+		// a key repeated later in rows compares against this record
+		byKey[key] = record
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	summary.Total = len(keys)
+
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := writer.Write(byKey[key]); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	return summary, err
+}
+
+// readMaster loads the master dataset; a missing file is an empty dataset.
+func readMaster(path string) ([]string, [][]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading master %s: %v", path, err)
+	}
+	if len(records) == 0 {
+		return nil, nil, nil
+	}
+	return records[0], records[1:], nil
+}
+
+func columnIndexes(header, names []string) ([]int, error) {
+	indexes := make([]int, len(names))
+	for i, name := range names {
+		indexes[i] = -1
+		for j, column := range header {
+			if column == name {
+				indexes[i] = j
+				break
+			}
+		}
+		if indexes[i] == -1 {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+	return indexes, nil
+}
+
+// recordKey joins the key columns with a separator that cannot appear in a
+// date or numeric ID, so keys sort by date first.
+func recordKey(record []string, keyIndex []int) string {
+	parts := make([]string, len(keyIndex))
+	for i, idx := range keyIndex {
+		if idx < len(record) {
+			parts[i] = record[idx]
+		}
+	}
+	return strings.Join(parts, "\x1f")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func equalRecords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFileAtomic writes to a temp file next to path and renames it into
+// place, so readers never see a half-written file. The result keeps the
+// mode of the file it replaces; a new file gets 0666 less the umask, as
+// os.Create would give it.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := createTempFile(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if info, err := os.Stat(path); err == nil {
+		if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// createTempFile creates a hidden, uniquely named file next to path.
+// Unlike os.CreateTemp it asks for 0666, so the umask applies as usual.
+func createTempFile(path string) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, fmt.Sprintf(".%s.%d.tmp", base, rand.Uint32()))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && try < 100 {
+			continue
+		}
+		return f, err
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomic_Mode(t *testing.T) {
+	dir := t.TempDir()
+	// os.Create applies the umask the same way
+	ref, err := os.Create(filepath.Join(dir, "ref"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref.Close()
+	want, _ := os.Stat(ref.Name())
+	os.Remove(ref.Name())
+
+	write := func(w io.Writer) error {
+		_, err := io.WriteString(w, "date\n")
+		return err
+	}
+	path := filepath.Join(dir, "new.csv")
+	if err := writeFileAtomic(path, write); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != want.Mode().Perm() {
+		t.Errorf("Expected a new file to get %v, got %v", want.Mode().Perm(), info.Mode().Perm())
+	}
+
+	path = filepath.Join(dir, "shared.csv")
+	os.WriteFile(path, nil, 0664)
+	os.Chmod(path, 0664)
+	if err := writeFileAtomic(path, write); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0664 {
+		t.Errorf("Expected the replaced file's mode kept, got %v", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Expected no temp files left, got %d entries", len(entries))
+	}
+}
+
+func TestUpsertMaster(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.csv")
+	layout := fieldColumns([]string{"date", "channel_id", "offer_id", "install"})
+	row := func(date string, offer, install int) *IAARow {
+		return decodeRow(t, fmt.Sprintf(`{"date":%q,"channel_id":1,"offer_id":%d,"install":%d}`, date, offer, install))
+	}
+
+	summary, err := upsertMaster(path, layout, []*IAARow{row("2025-07-05", 10, 5), row("2025-07-04", 10, 1), row("2025-07-04", 11, 2)}, defaultFormatOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (upsertSummary{Inserted: 3, Total: 3}); summary != want {
+		t.Errorf("Expected %v, got %v", want, summary)
+	}
+
+	// restated 07-04 offer 10, unchanged 07-04 offer 11 and a new day; the
+	// revenue column is new, but an empty value added to both is no change
+	layout = fieldColumns([]string{"date", "channel_id", "offer_id", "install", "revenue"})
+	summary, err = upsertMaster(path, layout, []*IAARow{row("2025-07-04", 10, 9), row("2025-07-04", 11, 2), row("2025-07-06", 10, 3)}, defaultFormatOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (upsertSummary{Inserted: 1, Updated: 1, Unchanged: 1, Total: 4}); summary != want {
+		t.Errorf("Expected %v, got %v", want, summary)
+	}
+	data, _ := os.ReadFile(path)
+	want := "date,channel_id,offer_id,install,revenue\n" +
+		"2025-07-04,1,10,9,\n" +
+		"2025-07-04,1,11,2,\n" +
+		"2025-07-05,1,10,5,\n" +
+		"2025-07-06,1,10,3,\n"
+	if string(data) != want {
+		t.Errorf("Expected the master sorted by key with the new column:\n%s\ngot\n%s", want, data)
+	}
+
+	summary, _ = upsertMaster(path, layout, []*IAARow{row("2025-07-06", 10, 3)}, defaultFormatOptions)
+	if want := (upsertSummary{Unchanged: 1, Total: 4}); summary != want {
+		t.Errorf("Expected %v, got %v", want, summary)
+	}
+
+	// a key repeated within one fetch is inserted once; later copies
+	// compare against it, and the last one wins
+	summary, _ = upsertMaster(path, layout, []*IAARow{row("2025-07-07", 10, 1), row("2025-07-07", 10, 1), row("2025-07-07", 10, 4)}, defaultFormatOptions)
+	if want := (upsertSummary{Inserted: 1, Updated: 1, Unchanged: 1, Total: 5}); summary != want {
+		t.Errorf("Expected %v, got %v", want, summary)
+	}
+	if data, _ := os.ReadFile(path); !strings.HasSuffix(string(data), "2025-07-07,1,10,4,\n") {
+		t.Errorf("Expected the last copy of a repeated key kept, got\n%s", data)
+	}
+}
+
+func TestIncrementalStart(t *testing.T) {
+	fallback := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		last    string
+		restate int
+		want    string
+	}{
+		{"", 7, "2025-06-01"},
+		// the last synced day itself is always fetched again
+		{"2025-07-10", 1, "2025-07-10"},
+		{"2025-07-10", 7, "2025-07-04"},
+		{"2025-03-02", 3, "2025-02-28"},
+	}
+	for _, c := range cases {
+		start, err := incrementalStart(&syncState{LastSyncedDate: c.last}, c.restate, fallback)
+		if err != nil || start.Format(dateLayout) != c.want {
+			t.Errorf("%q restating %d: expected %s, got %s, %v", c.last, c.restate, c.want, start.Format(dateLayout), err)
+		}
+	}
+	if _, err := incrementalStart(&syncState{LastSyncedDate: "07/10/2025"}, 7, fallback); err == nil {
+		t.Error("Expected a malformed last_synced_date to be rejected")
+	}
+}
+
+func TestSyncState(t *testing.T) {
+	dir := t.TempDir()
+	state, err := loadSyncState(filepath.Join(dir, "missing.json"))
+	if err != nil || state.LastSyncedDate != "" {
+		t.Errorf("Expected a missing state file to mean nothing synced, got %+v, %v", state, err)
+	}
+
+	path := filepath.Join(dir, "state.json")
+	if err := saveSyncState(path, &syncState{LastSyncedDate: "2025-07-10"}); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := loadSyncState(path); err != nil || state.LastSyncedDate != "2025-07-10" || state.UpdatedAt == "" {
+		t.Errorf("Unexpected saved state %+v, %v", state, err)
+	}
+
+	os.WriteFile(path, []byte(`{"last_synced_date": "2025-07`), 0644)
+	if _, err := loadSyncState(path); err == nil || !strings.Contains(err.Error(), "parsing sync state") {
+		t.Errorf("Expected a corrupt state file to be an error, got %v", err)
+	}
+}